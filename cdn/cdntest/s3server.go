@@ -0,0 +1,858 @@
+package cdntest
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Object as stored by S3Server.
+type S3Object struct {
+	Key          string
+	Data         []byte
+	ETag         string // quoted, as S3 returns it
+	LastModified time.Time
+	Header       http.Header // Content-Type, Cache-Control, Content-Disposition, x-amz-acl, x-amz-meta-*, x-amz-server-side-encryption* ...
+	Tags         url.Values
+}
+
+type multipartUpload struct {
+	bucket string
+	key    string
+	header http.Header
+	tags   url.Values
+	parts  map[int][]byte
+}
+
+// In process S3 compatible server for integration tests. Covers the subset of the API used by
+// cdn.S3Client: Put/Get/Head/Delete/Copy object, DeleteObjects, ListObjectsV2, object tagging,
+// GetObjectAcl for canned ACLs and multipart upload. Both path style (http://host/bucket/key) and virtual host style
+// (http://bucket.host/key) requests are routed. Objects written with an SSE-C key can only be read,
+// copied or have parts uploaded with the same key. Signatures are not verified but the SDK needs
+// some credentials to sign with. State is kept in memory and lost on Close.
+//
+//	srv := cdntest.NewS3Server("assets")
+//	defer srv.Close()
+//	client, err := cdn.CreateS3Client(cdn.S3ClientConfig{
+//		S3Endpoint:  srv.URL,
+//		S3PathStyle: true,
+//		S3Bucket:    "assets",
+//		S3Region:    "us-east-1",
+//		S3Key:       "test",
+//		S3Secret:    "test",
+//	})
+type S3Server struct {
+	*httptest.Server
+	mu      sync.Mutex
+	buckets map[string]map[string]*S3Object
+	uploads map[string]*multipartUpload
+	nextId  int
+	faults  []fault
+	delay   time.Duration
+}
+
+type fault struct {
+	method string
+	count  int
+	status int
+	code   string
+}
+
+// Starts a server with the given buckets created.
+func NewS3Server(buckets ...string) *S3Server {
+	s := &S3Server{
+		buckets: make(map[string]map[string]*S3Object),
+		uploads: make(map[string]*multipartUpload),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string]*S3Object)
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Returns a copy of the stored object.
+func (s *S3Server) Object(bucket string, key string) (S3Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		return S3Object{}, false
+	}
+	return obj.clone(), true
+}
+
+// Returns copies of every stored object in bucket sorted by key.
+func (s *S3Server) Objects(bucket string) []S3Object {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var objects []S3Object
+	for _, obj := range s.buckets[bucket] {
+		objects = append(objects, obj.clone())
+	}
+	slices.SortFunc(objects, func(a, b S3Object) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return objects
+}
+
+// Stores an object directly, bypassing HTTP.
+func (s *S3Server) PutObject(bucket string, obj S3Object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]*S3Object)
+	}
+	if obj.Header == nil {
+		obj.Header = make(http.Header)
+	}
+	if obj.LastModified.IsZero() {
+		obj.LastModified = time.Now().UTC()
+	}
+	obj.ETag = etag(obj.Data)
+	s.buckets[bucket][obj.Key] = &obj
+}
+
+// Makes the next count requests with method (any method when empty) fail with status and S3 error code,
+// ex: FailNext(http.MethodPut, 2, http.StatusServiceUnavailable, "SlowDown")
+func (s *S3Server) FailNext(method string, count int, status int, code string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = append(s.faults, fault{method: method, count: count, status: status, code: code})
+}
+
+// Delays every response by d.
+func (s *S3Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delay = d
+}
+
+func (s *S3Server) nextFault(method string) (fault, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.faults {
+		f := &s.faults[i]
+		if f.count > 0 && (f.method == "" || f.method == method) {
+			f.count--
+			return *f, true
+		}
+	}
+	return fault{}, false
+}
+
+// Number of multipart uploads that were created but neither completed nor aborted.
+func (s *S3Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.uploads)
+}
+
+func (o *S3Object) clone() S3Object {
+	c := *o
+	c.Data = slices.Clone(o.Data)
+	c.Header = o.Header.Clone()
+	c.Tags = url.Values(http.Header(o.Tags).Clone())
+	return c
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// Splits the request into bucket and key for both addressing styles.
+func (s *S3Server) route(r *http.Request) (string, string) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	serverHost, _, _ := net.SplitHostPort(strings.TrimPrefix(s.URL, "http://"))
+	if bucket, ok := strings.CutSuffix(host, "."+serverHost); ok {
+		return bucket, strings.TrimPrefix(r.URL.Path, "/")
+	}
+	if i := strings.Index(host, "."); i > 0 && net.ParseIP(host) == nil && host != serverHost {
+		s.mu.Lock()
+		_, ok := s.buckets[host[:i]]
+		s.mu.Unlock()
+		if ok {
+			return host[:i], strings.TrimPrefix(r.URL.Path, "/")
+		}
+	}
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	return bucket, key
+}
+
+type s3Error struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code string, msg string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	writeXML(w, status, s3Error{Code: code, Message: msg})
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(v)
+}
+
+func (s *S3Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if f, ok := s.nextFault(r.Method); ok {
+		io.Copy(io.Discard, r.Body)
+		writeError(w, r, f.status, f.code, "injected fault")
+		return
+	}
+
+	bucket, key := s.route(r)
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	switch {
+	case key == "" && r.Method == http.MethodGet && q.Get("list-type") == "2":
+		s.listObjectsV2(w, r, bucket)
+	case key == "" && r.Method == http.MethodPost && q.Has("delete"):
+		s.deleteObjects(w, r, bucket)
+	case key == "":
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "bucket operation not supported")
+	case r.Method == http.MethodPost && q.Has("uploads"):
+		s.createMultipartUpload(w, r, bucket, key)
+	case r.Method == http.MethodPost && q.Has("uploadId"):
+		s.completeMultipartUpload(w, r, bucket, key, q.Get("uploadId"))
+	case r.Method == http.MethodPut && q.Has("uploadId"):
+		s.uploadPart(w, r, q.Get("uploadId"), q.Get("partNumber"))
+	case r.Method == http.MethodDelete && q.Has("uploadId"):
+		s.abortMultipartUpload(w, r, q.Get("uploadId"))
+	case r.Method == http.MethodPut && q.Has("tagging"):
+		s.putObjectTagging(w, r, bucket, key)
+	case r.Method == http.MethodGet && q.Has("tagging"):
+		s.getObjectTagging(w, r, bucket, key)
+	case r.Method == http.MethodGet && q.Has("acl"):
+		s.getObjectACL(w, r, bucket, key)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucket, key)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucket, key)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		s.getObject(w, r, objects, key)
+	case r.Method == http.MethodDelete:
+		s.mu.Lock()
+		delete(objects, key)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource")
+	}
+}
+
+var storedHeaders = []string{
+	"Content-Type",
+	"Cache-Control",
+	"Content-Disposition",
+	"Content-Encoding",
+	"Content-Language",
+	"Expires",
+	"X-Amz-Acl",
+	"X-Amz-Server-Side-Encryption",
+	"X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id",
+	"X-Amz-Server-Side-Encryption-Customer-Algorithm",
+	"X-Amz-Server-Side-Encryption-Customer-Key-Md5",
+}
+
+func objectHeader(r *http.Request) http.Header {
+	h := make(http.Header)
+	for _, name := range storedHeaders {
+		if v := r.Header.Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	for name, v := range r.Header {
+		if strings.HasPrefix(strings.ToLower(name), "x-amz-meta-") {
+			h[name] = slices.Clone(v)
+		}
+	}
+	if enc := h.Get("Content-Encoding"); enc == "aws-chunked" {
+		h.Del("Content-Encoding")
+	}
+	if h.Get("Content-Type") == "" {
+		h.Set("Content-Type", "binary/octet-stream")
+	}
+	return h
+}
+
+func objectTags(r *http.Request) url.Values {
+	tags, _ := url.ParseQuery(r.Header.Get("X-Amz-Tagging"))
+	return tags
+}
+
+// Reads the request body, decoding aws-chunked bodies sent with streaming signatures or trailing checksums.
+func readBody(r *http.Request) ([]byte, error) {
+	sha := r.Header.Get("X-Amz-Content-Sha256")
+	if !strings.HasPrefix(sha, "STREAMING-") && !strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked") {
+		return io.ReadAll(r.Body)
+	}
+
+	var body bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		size, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid chunk size %q", line)
+		}
+		if size == 0 {
+			break
+		}
+		if _, err := io.CopyN(&body, br, size); err != nil {
+			return nil, err
+		}
+		if _, err := br.ReadString('\n'); err != nil {
+			return nil, err
+		}
+	}
+	return body.Bytes(), nil
+}
+
+func (s *S3Server) putObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	obj := &S3Object{
+		Key:          key,
+		Data:         data,
+		ETag:         etag(data),
+		LastModified: time.Now().UTC(),
+		Header:       objectHeader(r),
+		Tags:         objectTags(r),
+	}
+
+	s.mu.Lock()
+	s.buckets[bucket][key] = obj
+	s.mu.Unlock()
+
+	writeSSEHeaders(w, obj.Header)
+	w.Header().Set("ETag", obj.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+// S3 checks the SSE-C key of requests against the MD5 it stored the object with
+const sseCustomerKeyMD5 = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+
+func writeSSEHeaders(w http.ResponseWriter, h http.Header) {
+	for name, v := range h {
+		if strings.HasPrefix(name, "X-Amz-Server-Side-Encryption") {
+			w.Header()[name] = v
+		}
+	}
+}
+
+func (s *S3Server) getObject(w http.ResponseWriter, r *http.Request, objects map[string]*S3Object, key string) {
+	s.mu.Lock()
+	stored, ok := objects[key]
+	var obj S3Object
+	if ok {
+		obj = stored.clone()
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	if md5 := obj.Header.Get(sseCustomerKeyMD5); md5 != "" && r.Header.Get(sseCustomerKeyMD5) != md5 {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+		return
+	}
+
+	for name, v := range obj.Header {
+		if name == "X-Amz-Acl" {
+			continue
+		}
+		w.Header()[name] = v
+	}
+	if len(obj.Tags) > 0 {
+		w.Header().Set("X-Amz-Tagging-Count", strconv.Itoa(len(obj.Tags)))
+	}
+	w.Header().Set("ETag", obj.ETag)
+	w.Header().Set("Accept-Ranges", "bytes")
+	http.ServeContent(w, r, "", obj.LastModified, bytes.NewReader(obj.Data))
+}
+
+func (s *S3Server) copyObject(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	source, err := url.PathUnescape(strings.TrimPrefix(r.Header.Get("X-Amz-Copy-Source"), "/"))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid copy source")
+		return
+	}
+	source, _, _ = strings.Cut(source, "?")
+	srcBucket, srcKey, _ := strings.Cut(source, "/")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, ok := s.buckets[srcBucket][srcKey]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if md5 := src.Header.Get(sseCustomerKeyMD5); md5 != "" && r.Header.Get("X-Amz-Copy-Source-Server-Side-Encryption-Customer-Key-Md5") != md5 {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest", "The source object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+		return
+	}
+	if _, ok := s.buckets[bucket]; !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+
+	obj := src.clone()
+	obj.Key = key
+	obj.LastModified = time.Now().UTC()
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		obj.Header = objectHeader(r)
+	}
+	// the copy is encrypted as the request says, not as the source was
+	for name := range obj.Header {
+		if strings.HasPrefix(name, "X-Amz-Server-Side-Encryption") {
+			obj.Header.Del(name)
+		}
+	}
+	for name, v := range objectHeader(r) {
+		if strings.HasPrefix(name, "X-Amz-Server-Side-Encryption") {
+			obj.Header[name] = v
+		}
+	}
+	// like S3 the copy doesn't inherit the source's ACL, it is private unless the request sets one
+	obj.Header.Del("X-Amz-Acl")
+	if acl := r.Header.Get("X-Amz-Acl"); acl != "" {
+		obj.Header.Set("X-Amz-Acl", acl)
+	}
+	if r.Header.Get("X-Amz-Tagging-Directive") == "REPLACE" {
+		obj.Tags = objectTags(r)
+	}
+	s.buckets[bucket][key] = &obj
+
+	type copyObjectResult struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string   `xml:"ETag"`
+		LastModified string   `xml:"LastModified"`
+	}
+	writeXML(w, http.StatusOK, copyObjectResult{ETag: obj.ETag, LastModified: obj.LastModified.Format(time.RFC3339)})
+}
+
+func (s *S3Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string) {
+	type object struct {
+		Key string `xml:"Key"`
+	}
+	var req struct {
+		Quiet   bool     `xml:"Quiet"`
+		Objects []object `xml:"Object"`
+	}
+	data, err := readBody(r)
+	if err == nil {
+		err = xml.Unmarshal(data, &req)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+	if len(req.Objects) > 1000 {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", "too many objects")
+		return
+	}
+
+	type deleted struct {
+		Key string `xml:"Key"`
+	}
+	type result struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		Deleted []deleted `xml:"Deleted"`
+	}
+	var res result
+
+	s.mu.Lock()
+	for _, obj := range req.Objects {
+		delete(s.buckets[bucket], obj.Key)
+		if !req.Quiet {
+			res.Deleted = append(res.Deleted, deleted{Key: obj.Key})
+		}
+	}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, res)
+}
+
+func (s *S3Server) listObjectsV2(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	delimiter := q.Get("delimiter")
+	maxKeys := 1000
+	if v := q.Get("max-keys"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "invalid max-keys")
+			return
+		}
+		maxKeys = min(n, 1000)
+	}
+	after := q.Get("start-after")
+	if token := q.Get("continuation-token"); token != "" {
+		after = token
+	}
+
+	s.mu.Lock()
+	var keys []string
+	for key := range s.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) && key > after {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	type content struct {
+		Key          string `xml:"Key"`
+		LastModified string `xml:"LastModified"`
+		ETag         string `xml:"ETag"`
+		Size         int64  `xml:"Size"`
+		StorageClass string `xml:"StorageClass"`
+	}
+	type commonPrefix struct {
+		Prefix string `xml:"Prefix"`
+	}
+	type result struct {
+		XMLName               xml.Name       `xml:"ListBucketResult"`
+		Name                  string         `xml:"Name"`
+		Prefix                string         `xml:"Prefix"`
+		Delimiter             string         `xml:"Delimiter,omitempty"`
+		MaxKeys               int            `xml:"MaxKeys"`
+		KeyCount              int            `xml:"KeyCount"`
+		IsTruncated           bool           `xml:"IsTruncated"`
+		ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+		NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+		StartAfter            string         `xml:"StartAfter,omitempty"`
+		Contents              []content      `xml:"Contents"`
+		CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+	}
+	res := result{
+		Name:              bucket,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: q.Get("continuation-token"),
+		StartAfter:        q.Get("start-after"),
+	}
+
+	last := ""
+	for _, key := range keys {
+		if res.KeyCount == maxKeys {
+			res.IsTruncated = true
+			res.NextContinuationToken = last
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				p := key[:len(prefix)+i+len(delimiter)]
+				if len(res.CommonPrefixes) == 0 || res.CommonPrefixes[len(res.CommonPrefixes)-1].Prefix != p {
+					res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{Prefix: p})
+					res.KeyCount++
+				}
+				last = key
+				continue
+			}
+		}
+		obj := s.buckets[bucket][key]
+		res.Contents = append(res.Contents, content{
+			Key:          key,
+			LastModified: obj.LastModified.Format(time.RFC3339Nano),
+			ETag:         obj.ETag,
+			Size:         int64(len(obj.Data)),
+			StorageClass: "STANDARD",
+		})
+		res.KeyCount++
+		last = key
+	}
+	s.mu.Unlock()
+
+	writeXML(w, http.StatusOK, res)
+}
+
+type tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  struct {
+		Tags []struct {
+			Key   string `xml:"Key"`
+			Value string `xml:"Value"`
+		} `xml:"Tag"`
+	} `xml:"TagSet"`
+}
+
+func (s *S3Server) putObjectTagging(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	var req tagging
+	data, err := readBody(r)
+	if err == nil {
+		err = xml.Unmarshal(data, &req)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.buckets[bucket][key]
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	obj.Tags = make(url.Values)
+	for _, t := range req.TagSet.Tags {
+		obj.Tags.Set(t.Key, t.Value)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *S3Server) getObjectTagging(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	s.mu.Lock()
+	obj, ok := s.buckets[bucket][key]
+	var res tagging
+	if ok {
+		keys := make([]string, 0, len(obj.Tags))
+		for k := range obj.Tags {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for _, k := range keys {
+			res.TagSet.Tags = append(res.TagSet.Tags, struct {
+				Key   string `xml:"Key"`
+				Value string `xml:"Value"`
+			}{k, obj.Tags.Get(k)})
+		}
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	writeXML(w, http.StatusOK, res)
+}
+
+const (
+	allUsersURI           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	authenticatedUsersURI = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+	ownerID               = "cdntest"
+)
+
+// Grants S3 reports for a canned ACL, besides the owner's FULL_CONTROL.
+var cannedGrants = map[string][][2]string{
+	"public-read":        {{allUsersURI, "READ"}},
+	"public-read-write":  {{allUsersURI, "READ"}, {allUsersURI, "WRITE"}},
+	"authenticated-read": {{authenticatedUsersURI, "READ"}},
+}
+
+func (s *S3Server) getObjectACL(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	s.mu.Lock()
+	obj, ok := s.buckets[bucket][key]
+	var acl string
+	if ok {
+		acl = obj.Header.Get("X-Amz-Acl")
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	var body strings.Builder
+	body.WriteString(xml.Header)
+	body.WriteString(`<AccessControlPolicy xmlns="http://s3.amazonaws.com/doc/2006-03-01/">`)
+	fmt.Fprintf(&body, `<Owner><ID>%s</ID></Owner><AccessControlList>`, ownerID)
+	const grantee = `<Grant><Grantee xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance" xsi:type="%s">%s</Grantee><Permission>%s</Permission></Grant>`
+	fmt.Fprintf(&body, grantee, "CanonicalUser", "<ID>"+ownerID+"</ID>", "FULL_CONTROL")
+	for _, g := range cannedGrants[acl] {
+		fmt.Fprintf(&body, grantee, "Group", "<URI>"+g[0]+"</URI>", g[1])
+	}
+	body.WriteString(`</AccessControlList></AccessControlPolicy>`)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, body.String())
+}
+
+func (s *S3Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string) {
+	s.mu.Lock()
+	s.nextId++
+	id := fmt.Sprintf("upload-%d", s.nextId)
+	s.uploads[id] = &multipartUpload{
+		bucket: bucket,
+		key:    key,
+		header: objectHeader(r),
+		tags:   objectTags(r),
+		parts:  make(map[int][]byte),
+	}
+	s.mu.Unlock()
+
+	type result struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadId string   `xml:"UploadId"`
+	}
+	writeXML(w, http.StatusOK, result{Bucket: bucket, Key: key, UploadId: id})
+}
+
+func (s *S3Server) uploadPart(w http.ResponseWriter, r *http.Request, id string, partNumber string) {
+	n, err := strconv.Atoi(partNumber)
+	if err != nil || n < 1 || n > 10000 {
+		writeError(w, r, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive")
+		return
+	}
+	data, err := readBody(r)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	upload, ok := s.uploads[id]
+	keyMatches := ok && upload.header.Get(sseCustomerKeyMD5) == r.Header.Get(sseCustomerKeyMD5)
+	if keyMatches {
+		upload.parts[n] = data
+	}
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	if !keyMatches {
+		writeError(w, r, http.StatusBadRequest, "InvalidRequest", "The SSE-C key of the part doesn't match the one the upload was created with.")
+		return
+	}
+
+	w.Header().Set("ETag", etag(data))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *S3Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucket string, key string, id string) {
+	var req struct {
+		Parts []struct {
+			PartNumber int    `xml:"PartNumber"`
+			ETag       string `xml:"ETag"`
+		} `xml:"Part"`
+	}
+	data, err := readBody(r)
+	if err == nil {
+		err = xml.Unmarshal(data, &req)
+	}
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, ok := s.uploads[id]
+	if !ok || upload.bucket != bucket || upload.key != key {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+
+	var body bytes.Buffer
+	sums := md5.New()
+	prev := 0
+	for i, p := range req.Parts {
+		part, ok := upload.parts[p.PartNumber]
+		if !ok || etag(part) != p.ETag {
+			writeError(w, r, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+			return
+		}
+		if p.PartNumber <= prev {
+			writeError(w, r, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+			return
+		}
+		if i < len(req.Parts)-1 && len(part) < 5*1024*1024 {
+			writeError(w, r, http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size.")
+			return
+		}
+		prev = p.PartNumber
+		body.Write(part)
+		sum := md5.Sum(part)
+		sums.Write(sum[:])
+	}
+
+	obj := &S3Object{
+		Key:          key,
+		Data:         body.Bytes(),
+		ETag:         fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(sums.Sum(nil)), len(req.Parts)),
+		LastModified: time.Now().UTC(),
+		Header:       upload.header,
+		Tags:         upload.tags,
+	}
+	s.buckets[bucket][key] = obj
+	delete(s.uploads, id)
+
+	type result struct {
+		XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+		Bucket  string   `xml:"Bucket"`
+		Key     string   `xml:"Key"`
+		ETag    string   `xml:"ETag"`
+	}
+	writeXML(w, http.StatusOK, result{Bucket: bucket, Key: key, ETag: obj.ETag})
+}
+
+func (s *S3Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, id string) {
+	s.mu.Lock()
+	_, ok := s.uploads[id]
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist.")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,133 @@
+package cdn
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/url"
+	"strings"
+)
+
+var ErrInvalidDataURI = errors.New("invalid data uri")
+
+type DataURI struct {
+	MediaType string // ex: image/png
+	Data      []byte
+}
+
+// Extensions uploads of a media type are stored under. A fixed table since mime.ExtensionsByType
+// depends on the host's mime files and returns several in no useful order, ex: "ehtml" for text/html
+var typeExtensions = map[string]string{
+	"image/png":        "png",
+	"image/jpeg":       "jpg",
+	"image/gif":        "gif",
+	"image/webp":       "webp",
+	"image/avif":       "avif",
+	"image/bmp":        "bmp",
+	"image/svg+xml":    "svg",
+	"application/pdf":  "pdf",
+	"application/json": "json",
+	"application/xml":  "xml",
+	"application/zip":  "zip",
+	"text/plain":       "txt",
+	"text/csv":         "csv",
+	"text/html":        "html",
+	"text/xml":         "xml",
+	"video/mp4":        "mp4",
+	"video/webm":       "webm",
+	"audio/mpeg":       "mp3",
+	"audio/wave":       "wav",
+}
+
+// Parses a RFC 2397 data URI, ex: data:image/png;base64,iVBORw0KGgo...
+// Both base64 and percent encoded data is accepted. For images the decoded content must match the
+// declared media type.
+func ParseDataURI(uri string) (DataURI, error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	if !ok {
+		return DataURI{}, fmt.Errorf("%w: missing \"data:\" scheme", ErrInvalidDataURI)
+	}
+
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return DataURI{}, fmt.Errorf("%w: missing \",\" before data", ErrInvalidDataURI)
+	}
+
+	isBase64 := false
+	if h, ok := strings.CutSuffix(header, ";base64"); ok {
+		header = h
+		isBase64 = true
+	}
+	if header == "" {
+		header = "text/plain;charset=US-ASCII"
+	}
+
+	declared, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return DataURI{}, fmt.Errorf("%w: media type \"%s\": %w", ErrInvalidDataURI, header, err)
+	}
+
+	var data []byte
+	if isBase64 {
+		// some encoders strip the padding or wrap lines
+		payload = strings.Join(strings.Fields(payload), "")
+		data, err = base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			data, err = base64.RawStdEncoding.DecodeString(payload)
+		}
+		if err != nil {
+			return DataURI{}, fmt.Errorf("%w: base64: %w", ErrInvalidDataURI, err)
+		}
+	} else {
+		unescaped, err := url.PathUnescape(payload)
+		if err != nil {
+			return DataURI{}, fmt.Errorf("%w: %w", ErrInvalidDataURI, err)
+		}
+		data = []byte(unescaped)
+	}
+
+	if len(data) == 0 {
+		return DataURI{}, fmt.Errorf("%w: no data", ErrInvalidDataURI)
+	}
+
+	// DetectContentType doesn't know avif
+	if strings.HasPrefix(declared, "image/") && declared != "image/avif" {
+		if sniffed := mediaType(DetectContentType(data)); sniffed != declared {
+			return DataURI{}, fmt.Errorf("%w: declared %s but content is %s", ErrInvalidDataURI, declared, sniffed)
+		}
+	}
+
+	return DataURI{MediaType: declared, Data: data}, nil
+}
+
+// File extension for the media type, without the dot.
+func (d DataURI) Extension() (string, error) {
+	ext, ok := typeExtension(d.MediaType)
+	if !ok {
+		return "", fmt.Errorf("%w: no extension for %s", ErrInvalidDataURI, d.MediaType)
+	}
+	return ext, nil
+}
+
+func typeExtension(mt string) (string, bool) {
+	ext, ok := typeExtensions[mt]
+	return ext, ok
+}
+
+// Decodes a data URI and uploads it with UploadFile, ex: a cropped avatar sent by the frontend.
+// Malformed input returns an error wrapping ErrInvalidDataURI.
+func UploadDataURI(ctx context.Context, c Cdn, uri string) (string, error) {
+	d, err := ParseDataURI(uri)
+	if err != nil {
+		return "", err
+	}
+
+	ext, err := d.Extension()
+	if err != nil {
+		return "", err
+	}
+
+	return c.UploadFileContext(ctx, d.Data, ext, d.MediaType)
+}
@@ -0,0 +1,71 @@
+package cdn_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+)
+
+func TestDataURIExtension(t *testing.T) {
+	for mt, want := range map[string]string{
+		"image/jpeg": "jpg",
+		"text/html":  "html",
+		"text/xml":   "xml",
+		"video/mp4":  "mp4",
+	} {
+		ext, err := cdn.DataURI{MediaType: mt}.Extension()
+		if err != nil || ext != want {
+			t.Fatalf("%s: extension %q, %v, want %q", mt, ext, err, want)
+		}
+	}
+
+	// types outside the table have no extension rather than whatever the host's mime files list first
+	if _, err := (cdn.DataURI{MediaType: "application/x-unknown"}).Extension(); !errors.Is(err, cdn.ErrInvalidDataURI) {
+		t.Fatalf("unknown type: %v, want ErrInvalidDataURI", err)
+	}
+}
+
+func TestParseDataURI(t *testing.T) {
+	png := base64.StdEncoding.EncodeToString(pngMagic)
+	for uri, want := range map[string]cdn.DataURI{
+		"data:image/png;base64," + png:                      {MediaType: "image/png", Data: pngMagic},
+		"data:image/png;base64," + png[:4] + "\n" + png[4:]: {MediaType: "image/png", Data: pngMagic},
+		"data:text/plain;charset=utf-8,a%20b":               {MediaType: "text/plain", Data: []byte("a b")},
+		"data:,hi":                                          {MediaType: "text/plain", Data: []byte("hi")},
+		"data:application/json;base64," + "eyJhIjoxfQ":      {MediaType: "application/json", Data: []byte(`{"a":1}`)},
+	} {
+		d, err := cdn.ParseDataURI(uri)
+		if err != nil || d.MediaType != want.MediaType || !bytes.Equal(d.Data, want.Data) {
+			t.Fatalf("%.40s: %s %q, %v", uri, d.MediaType, d.Data, err)
+		}
+	}
+}
+
+func TestParseDataURIErrors(t *testing.T) {
+	for name, uri := range map[string]string{
+		"missing scheme":   "image/png;base64," + base64.StdEncoding.EncodeToString(pngMagic),
+		"missing comma":    "data:image/png;base64",
+		"bad media type":   "data:image/;base64,aGk=",
+		"bad base64":       "data:image/png;base64,not base64!",
+		"bad escape":       "data:text/plain,100%",
+		"no data":          "data:text/plain,",
+		"mismatched image": "data:image/png;base64," + base64.StdEncoding.EncodeToString(jpegMagic),
+		"html as image":    "data:image/png," + "<script>alert(1)</script>",
+	} {
+		if _, err := cdn.ParseDataURI(uri); !errors.Is(err, cdn.ErrInvalidDataURI) {
+			t.Fatalf("%s: %v, want ErrInvalidDataURI", name, err)
+		}
+	}
+
+	c := newMemoryClient(t)
+	if _, err := cdn.UploadDataURI(context.Background(), c, "data:image/png,<svg/>"); !errors.Is(err, cdn.ErrInvalidDataURI) {
+		t.Fatalf("UploadDataURI = %v, want ErrInvalidDataURI", err)
+	}
+	if n := len(c.Objects()); n != 0 {
+		t.Fatalf("%d objects stored, want 0", n)
+	}
+}
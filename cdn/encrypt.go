@@ -0,0 +1,325 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrUnknownKey    = errors.New("encryption key id is unknown")
+	ErrDecryptFailed = errors.New("file can't be decrypted")
+)
+
+const (
+	envelopeMagic = "CEN1"
+	dataKeySize   = 32
+	maxKeyIDLen   = 255
+)
+
+// Layout of an encrypted file, the data key is wrapped with the key encryption key named by key id:
+//
+//	magic | len(key id) | key id | wrap nonce | wrapped data key + tag | data nonce | ciphertext + tag
+func envelopeHeaderLen(keyIDLen int) int {
+	return len(envelopeMagic) + 1 + keyIDLen + 12 + dataKeySize + 16 + 12
+}
+
+func envelopeOverhead(keyIDLen int) int {
+	return envelopeHeaderLen(keyIDLen) + 16
+}
+
+type EncryptionConfig struct {
+	// Key ID -> 32 byte AES-256 key encryption key. Keep retired keys so files written with them
+	// can still be read, ex: {"2026-10": key}
+	Keys         map[string][]byte
+	CurrentKeyID string // new files are encrypted under this key
+}
+
+// Cdn that encrypts files with AES-256-GCM before they reach the wrapped Cdn and decrypts them on
+// reads. Every file gets its own random data key which is stored with the file, wrapped by the
+// current key encryption key, so rotating keys only needs RewrapFile instead of re-encrypting content.
+//
+// The wrapped Cdn only ever sees ciphertext: its UploadPolicy can't inspect content, ListFiles
+// reports encrypted sizes and SetImage URLs serve ciphertext. Ranged reads decrypt the whole file.
+type EncryptedClient struct {
+	Cdn
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+var _ Cdn = (*EncryptedClient)(nil)
+
+func CreateEncryptedClient(c Cdn, config EncryptionConfig) (*EncryptedClient, error) {
+	if c == nil {
+		return nil, fmt.Errorf("cdn is required")
+	}
+	if _, ok := config.Keys[config.CurrentKeyID]; !ok {
+		return nil, fmt.Errorf("current key id \"%s\" is invalid: must be one of Keys", config.CurrentKeyID)
+	}
+
+	keys := make(map[string]cipher.AEAD, len(config.Keys))
+	for id, key := range config.Keys {
+		if len(id) == 0 || len(id) > maxKeyIDLen {
+			return nil, fmt.Errorf("key id \"%s\" is invalid: must be 1-%d bytes", id, maxKeyIDLen)
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("key \"%s\" is invalid: must be 32 bytes, got %d", id, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		keys[id] = aead
+	}
+
+	client := &EncryptedClient{
+		Cdn:     c,
+		keys:    keys,
+		current: config.CurrentKeyID,
+	}
+
+	return client, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wraps dataKey with the key encryption key keyID, returning the header up to the data nonce.
+func (c *EncryptedClient) wrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	kek, ok := c.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("key id \"%s\": %w", keyID, ErrUnknownKey)
+	}
+
+	header := make([]byte, 0, envelopeHeaderLen(len(keyID)))
+	header = append(header, envelopeMagic...)
+	header = append(header, byte(len(keyID)))
+	header = append(header, keyID...)
+	aad := bytes.Clone(header)
+
+	nonce := make([]byte, kek.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	header = append(header, nonce...)
+	header = kek.Seal(header, nonce, dataKey, aad)
+
+	return header, nil
+}
+
+// Parses the header of an encrypted file and unwraps its data key.
+// Returns the key id, the data key and the offset of the data nonce.
+func (c *EncryptedClient) unwrapKey(data []byte) (string, []byte, int, error) {
+	if len(data) < envelopeOverhead(0) || string(data[:len(envelopeMagic)]) != envelopeMagic {
+		return "", nil, 0, fmt.Errorf("%w: not an encrypted file", ErrDecryptFailed)
+	}
+	idLen := int(data[len(envelopeMagic)])
+	if len(data) < envelopeOverhead(idLen) {
+		return "", nil, 0, fmt.Errorf("%w: truncated", ErrDecryptFailed)
+	}
+
+	i := len(envelopeMagic) + 1
+	keyID := string(data[i : i+idLen])
+	aad := data[:i+idLen]
+	i += idLen
+
+	kek, ok := c.keys[keyID]
+	if !ok {
+		return "", nil, 0, fmt.Errorf("key id \"%s\": %w", keyID, ErrUnknownKey)
+	}
+
+	nonce := data[i : i+12]
+	wrapped := data[i+12 : i+12+dataKeySize+16]
+	dataKey, err := kek.Open(nil, nonce, wrapped, aad)
+	if err != nil {
+		return "", nil, 0, fmt.Errorf("%w: data key: %w", ErrDecryptFailed, err)
+	}
+
+	return keyID, dataKey, i + 12 + dataKeySize + 16, nil
+}
+
+func (c *EncryptedClient) encrypt(plaintext []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	header, err := c.wrapKey(c.current, dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 0, len(header)+len(nonce)+len(plaintext)+aead.Overhead())
+	out = append(out, header...)
+	out = append(out, nonce...)
+	// the header isn't authenticated with the content so RewrapFile can replace it
+	return aead.Seal(out, nonce, plaintext, []byte(envelopeMagic)), nil
+}
+
+func (c *EncryptedClient) decrypt(data []byte) ([]byte, error) {
+	_, dataKey, i, err := c.unwrapKey(data)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := aead.Open(nil, data[i:i+12], data[i+12:], []byte(envelopeMagic))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptFailed, err)
+	}
+
+	return plaintext, nil
+}
+
+// The content type is sniffed from the plaintext when empty, the wrapped Cdn would only see ciphertext.
+func plaintextContentType(data []byte, contentType string) string {
+	if len(contentType) > 0 {
+		return contentType
+	}
+	return DetectContentType(data)
+}
+
+func (c *EncryptedClient) UploadFile(data []byte, ext string, contentType string) (string, error) {
+	return c.UploadFileContext(context.Background(), data, ext, contentType)
+}
+
+func (c *EncryptedClient) UploadFileContext(ctx context.Context, data []byte, ext string, contentType string) (string, error) {
+	encrypted, err := c.encrypt(data)
+	if err != nil {
+		return "", err
+	}
+	return c.Cdn.UploadFileContext(ctx, encrypted, ext, plaintextContentType(data, contentType))
+}
+
+func (c *EncryptedClient) PutFile(filename string, data []byte, contentType string) error {
+	return c.PutFileContext(context.Background(), filename, data, contentType)
+}
+
+func (c *EncryptedClient) PutFileContext(ctx context.Context, filename string, data []byte, contentType string) error {
+	encrypted, err := c.encrypt(data)
+	if err != nil {
+		return err
+	}
+	return c.Cdn.PutFileContext(ctx, filename, encrypted, plaintextContentType(data, contentType))
+}
+
+// Reads and decrypts filename. Files that fail authentication return an error wrapping ErrDecryptFailed.
+func (c *EncryptedClient) GetFile(ctx context.Context, filename string) (io.ReadCloser, ObjectMetadata, error) {
+	data, meta, err := c.readFile(ctx, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), meta, nil
+}
+
+// GCM can only authenticate the whole file, so it is downloaded and decrypted before slicing.
+func (c *EncryptedClient) GetFileRange(ctx context.Context, filename string, offset int64, length int64) (io.ReadCloser, ObjectMetadata, error) {
+	if err := validateRange(offset, length); err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	data, meta, err := c.readFile(ctx, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	n, err := rangeLength(offset, length, meta.Size)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data[offset : offset+n])), meta, nil
+}
+
+// Size is the plaintext size, read from the key id length at the start of the file.
+func (c *EncryptedClient) HeadFile(ctx context.Context, filename string) (ObjectMetadata, error) {
+	meta, err := c.Cdn.HeadFile(ctx, filename)
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+
+	body, _, err := c.Cdn.GetFileRange(ctx, filename, 0, int64(len(envelopeMagic)+1))
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+	defer body.Close()
+
+	prefix, err := io.ReadAll(body)
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+	if len(prefix) != len(envelopeMagic)+1 || string(prefix[:len(envelopeMagic)]) != envelopeMagic {
+		return ObjectMetadata{}, fmt.Errorf("%w: not an encrypted file", ErrDecryptFailed)
+	}
+
+	meta.Size -= int64(envelopeOverhead(int(prefix[len(envelopeMagic)])))
+	return meta, nil
+}
+
+func (c *EncryptedClient) readFile(ctx context.Context, filename string) ([]byte, ObjectMetadata, error) {
+	data, meta, err := ReadFile(ctx, c.Cdn, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	plaintext, err := c.decrypt(data)
+	if err != nil {
+		return nil, ObjectMetadata{}, fmt.Errorf("file \"%s\": %w", filename, err)
+	}
+
+	meta.Size = int64(len(plaintext))
+	return plaintext, meta, nil
+}
+
+// Re-wraps the data key of filename with the current key so the key it was written with can be
+// retired. The content isn't re-encrypted. Returns false if it already uses the current key.
+func (c *EncryptedClient) RewrapFile(ctx context.Context, filename string) (bool, error) {
+	data, meta, err := ReadFile(ctx, c.Cdn, filename)
+	if err != nil {
+		return false, err
+	}
+
+	keyID, dataKey, i, err := c.unwrapKey(data)
+	if err != nil {
+		return false, fmt.Errorf("file \"%s\": %w", filename, err)
+	}
+	if keyID == c.current {
+		return false, nil
+	}
+
+	header, err := c.wrapKey(c.current, dataKey)
+	if err != nil {
+		return false, err
+	}
+
+	rewrapped := append(header, data[i:]...)
+	if err := c.Cdn.PutFileContext(ctx, filename, rewrapped, meta.ContentType); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,178 @@
+package cdn_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+	"github.com/c-malecki/go-utils/cdn/cdntest"
+)
+
+var (
+	oldKey = bytes.Repeat([]byte{1}, 32)
+	newKey = bytes.Repeat([]byte{2}, 32)
+)
+
+func newEncryptedClient(t *testing.T, c cdn.Cdn, current string, keys map[string][]byte) *cdn.EncryptedClient {
+	t.Helper()
+	e, err := cdn.CreateEncryptedClient(c, cdn.EncryptionConfig{Keys: keys, CurrentKeyID: current})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func readAll(t *testing.T, c cdn.Cdn, filename string) ([]byte, error) {
+	t.Helper()
+	body, _, err := c.GetFile(context.Background(), filename)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	m := newMemoryClient(t)
+	e := newEncryptedClient(t, m, "old", map[string][]byte{"old": oldKey})
+	ctx := context.Background()
+	plaintext := []byte("name,salary\nalice,100\n")
+
+	if err := e.PutFile("payroll.csv", plaintext, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, _ := m.Object("payroll.csv")
+	if bytes.Contains(stored.Data, []byte("alice")) {
+		t.Fatal("plaintext reached the wrapped cdn")
+	}
+	if stored.ContentType != "text/plain; charset=utf-8" {
+		t.Fatalf("content type %q, want the plaintext's", stored.ContentType)
+	}
+
+	got, err := readAll(t, e, "payroll.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, plaintext) {
+		t.Fatalf("decrypted %q", got)
+	}
+
+	meta, err := e.HeadFile(ctx, "payroll.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != int64(len(plaintext)) {
+		t.Fatalf("HeadFile size %d, want %d", meta.Size, len(plaintext))
+	}
+
+	body, _, err := e.GetFileRange(ctx, "payroll.csv", 12, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	if part, _ := io.ReadAll(body); string(part) != "alice" {
+		t.Fatalf("range read %q, want alice", part)
+	}
+}
+
+func TestEncryptedRewrap(t *testing.T) {
+	m := newMemoryClient(t)
+	before := newEncryptedClient(t, m, "old", map[string][]byte{"old": oldKey})
+	if err := before.PutFile("a.txt", []byte("secret"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	rotated := newEncryptedClient(t, m, "new", map[string][]byte{"old": oldKey, "new": newKey})
+	if got, err := readAll(t, rotated, "a.txt"); err != nil || string(got) != "secret" {
+		t.Fatalf("reading with a retired key: %q, %v", got, err)
+	}
+
+	rewrapped, err := rotated.RewrapFile(ctx, "a.txt")
+	if err != nil || !rewrapped {
+		t.Fatalf("RewrapFile = %v, %v", rewrapped, err)
+	}
+	if rewrapped, err := rotated.RewrapFile(ctx, "a.txt"); err != nil || rewrapped {
+		t.Fatalf("second RewrapFile = %v, %v, want false", rewrapped, err)
+	}
+
+	// the old key can be dropped once every file is rewrapped
+	after := newEncryptedClient(t, m, "new", map[string][]byte{"new": newKey})
+	if got, err := readAll(t, after, "a.txt"); err != nil || string(got) != "secret" {
+		t.Fatalf("reading after the rewrap: %q, %v", got, err)
+	}
+	if _, err := readAll(t, before, "a.txt"); !errors.Is(err, cdn.ErrUnknownKey) {
+		t.Fatalf("reading with only the old key = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestEncryptedTampering(t *testing.T) {
+	m := newMemoryClient(t)
+	e := newEncryptedClient(t, m, "old", map[string][]byte{"old": oldKey})
+	if err := e.PutFile("a.txt", []byte("secret"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := m.Object("a.txt")
+
+	for name, offset := range map[string]int{
+		"content":     len(stored.Data) - 1,
+		"wrapped key": len("CEN1") + 1 + len("old") + 12,
+	} {
+		tampered := bytes.Clone(stored.Data)
+		tampered[offset] ^= 0xff
+		if err := m.PutFile("tampered.txt", tampered, "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readAll(t, e, "tampered.txt"); !errors.Is(err, cdn.ErrDecryptFailed) {
+			t.Fatalf("%s: %v, want ErrDecryptFailed", name, err)
+		}
+	}
+
+	if err := m.PutFile("plain.txt", []byte("not encrypted at all"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.HeadFile(context.Background(), "plain.txt"); !errors.Is(err, cdn.ErrDecryptFailed) {
+		t.Fatalf("HeadFile of a plain file = %v, want ErrDecryptFailed", err)
+	}
+}
+
+func TestSSECustomerKey(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{SSECustomerKey: oldKey, MultipartPartSize: 5 << 20})
+	other := newS3Client(t, srv, cdn.S3ClientConfig{SSECustomerKey: newKey})
+	ctx := context.Background()
+
+	if err := c.PutFile("a.txt", []byte("secret"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if meta, err := c.HeadFile(ctx, "a.txt"); err != nil || meta.Size != 6 {
+		t.Fatalf("HeadFile = %+v, %v", meta, err)
+	}
+	if _, err := other.HeadFile(ctx, "a.txt"); err == nil {
+		t.Fatal("HeadFile succeeded with another key")
+	}
+
+	if err := c.CopyFile(ctx, "a.txt", "b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAll(t, c, "b.txt"); err != nil || string(got) != "secret" {
+		t.Fatalf("copy read %q, %v", got, err)
+	}
+	if err := other.CopyFile(ctx, "a.txt", "c.txt"); err == nil {
+		t.Fatal("CopyFile succeeded with another key")
+	}
+
+	data := bytes.Repeat([]byte("x"), 11<<20)
+	filename, err := c.UploadStream(ctx, bytes.NewReader(data), "bin", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAll(t, c, filename); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("multipart read %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,185 @@
+package cdn
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"iter"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Yields every filename still referenced, ex: from the DB columns UploadFile results are stored in.
+type References func(ctx context.Context) iter.Seq2[string, error]
+
+type GCConfig struct {
+	Prefix string // only filenames under this prefix are collected, "" for everything under PublicPrefix
+	// Unreferenced objects younger than this are kept. Must be longer than the time between an upload
+	// and its filename being saved, or files of in flight requests get deleted. Required unless DryRun
+	GracePeriod time.Duration
+	MaxDeletes  int  // deletions per run, 0 for no limit
+	DryRun      bool // report orphans without deleting them
+	// Delete variants created by UploadImage even when their original is referenced, by default they
+	// are kept since only the original's filename is saved
+	DeleteVariants bool
+	// Filename prefixes that are never collected, default "trash/" and "tmp/" where TrashClient and
+	// StagingClient keep files that are never referenced. Set it when those use other prefixes, an
+	// empty non nil slice excludes nothing
+	Exclude []string
+}
+
+type GCReport struct {
+	Scanned    int          // objects listed
+	Referenced int          // listed objects that are referenced
+	Excluded   int          // listed objects under an Exclude prefix
+	Young      int          // unreferenced objects kept because of GracePeriod
+	Orphans    []ObjectInfo // unreferenced objects past GracePeriod, at most MaxDeletes
+	Capped     bool         // there were more orphans than MaxDeletes
+	Deleted    []string     // empty for a dry run
+	Failed     []DeleteFailure
+}
+
+// Reads references from a query selecting a single filename column. NULL and empty values are skipped.
+// ex: SQLReferences(db, "SELECT picture FROM users UNION SELECT logo FROM companies")
+func SQLReferences(db *sql.DB, query string, args ...any) References {
+	return func(ctx context.Context) iter.Seq2[string, error] {
+		return func(yield func(string, error) bool) {
+			rows, err := db.QueryContext(ctx, query, args...)
+			if err != nil {
+				yield("", fmt.Errorf("db.QueryContext: %w", err))
+				return
+			}
+			defer rows.Close()
+
+			for rows.Next() {
+				var filename sql.NullString
+				if err := rows.Scan(&filename); err != nil {
+					yield("", fmt.Errorf("rows.Scan: %w", err))
+					return
+				}
+				if !filename.Valid || filename.String == "" {
+					continue
+				}
+				if !yield(filename.String, nil) {
+					return
+				}
+			}
+			if err := rows.Err(); err != nil {
+				yield("", fmt.Errorf("rows.Err: %w", err))
+			}
+		}
+	}
+}
+
+// Filename of a variant without extension and without the suffix added by VariantFilename.
+func variantStem(filename string) (string, bool) {
+	stem := strings.TrimSuffix(filename, path.Ext(filename))
+	if i := strings.LastIndex(stem, "_"); i > 0 && !strings.Contains(stem[i:], "/") {
+		return stem[:i], true
+	}
+	return "", false
+}
+
+type batchDeleter interface {
+	DeleteFiles(ctx context.Context, filenames []string) (DeleteResult, error)
+}
+
+// Deletes filenames with DeleteFiles when c has it, otherwise one by one collecting failures.
+func deleteFiles(ctx context.Context, c Cdn, filenames []string) (DeleteResult, error) {
+	if bd, ok := c.(batchDeleter); ok {
+		return bd.DeleteFiles(ctx, filenames)
+	}
+
+	var result DeleteResult
+	for _, filename := range filenames {
+		if err := c.DeleteFileContext(ctx, filename); err != nil {
+			result.Failed = append(result.Failed, DeleteFailure{Filename: filename, Message: err.Error()})
+			continue
+		}
+		result.Deleted = append(result.Deleted, filename)
+	}
+
+	return result, nil
+}
+
+// Deletes objects under config.Prefix that refs no longer yields and that are older than
+// config.GracePeriod. References are loaded before the bucket is listed so files uploaded during
+// the run are protected by the grace period.
+//
+// Files a TrashClient or StagingClient keeps under their prefixes are never referenced, config.Exclude
+// keeps them from being collected before they can be restored or committed.
+func CollectGarbage(ctx context.Context, c Cdn, refs References, config GCConfig) (GCReport, error) {
+	var report GCReport
+
+	if config.GracePeriod <= 0 && !config.DryRun {
+		return report, fmt.Errorf("grace period %s is invalid: must be positive unless DryRun is set", config.GracePeriod)
+	}
+	if config.Exclude == nil {
+		config.Exclude = []string{defaultTrashPrefix + "/", defaultStagingPrefix + "/"}
+	}
+
+	referenced := make(map[string]struct{})
+	stems := make(map[string]struct{})
+	for filename, err := range refs(ctx) {
+		if err != nil {
+			return report, fmt.Errorf("references: %w", err)
+		}
+		referenced[filename] = struct{}{}
+		if !config.DeleteVariants {
+			stems[strings.TrimSuffix(filename, path.Ext(filename))] = struct{}{}
+		}
+	}
+
+	cutoff := time.Now().Add(-config.GracePeriod)
+
+	for obj, err := range c.ListFiles(ctx, config.Prefix) {
+		if err != nil {
+			return report, fmt.Errorf("list files: %w", err)
+		}
+		report.Scanned++
+
+		if slices.ContainsFunc(config.Exclude, func(prefix string) bool {
+			return strings.HasPrefix(obj.Filename, prefix)
+		}) {
+			report.Excluded++
+			continue
+		}
+		if _, ok := referenced[obj.Filename]; ok {
+			report.Referenced++
+			continue
+		}
+		if stem, ok := variantStem(obj.Filename); ok && !config.DeleteVariants {
+			if _, ok := stems[stem]; ok {
+				report.Referenced++
+				continue
+			}
+		}
+		if obj.LastModified.After(cutoff) {
+			report.Young++
+			continue
+		}
+
+		if config.MaxDeletes > 0 && len(report.Orphans) == config.MaxDeletes {
+			report.Capped = true
+			continue
+		}
+		report.Orphans = append(report.Orphans, obj)
+	}
+
+	if config.DryRun || len(report.Orphans) == 0 {
+		return report, nil
+	}
+
+	filenames := make([]string, len(report.Orphans))
+	for i, obj := range report.Orphans {
+		filenames[i] = obj.Filename
+	}
+
+	res, err := deleteFiles(ctx, c, filenames)
+	report.Deleted = res.Deleted
+	report.Failed = res.Failed
+
+	return report, err
+}
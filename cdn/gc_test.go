@@ -0,0 +1,59 @@
+package cdn_test
+
+import (
+	"context"
+	"iter"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/c-malecki/go-utils/cdn"
+	"github.com/c-malecki/go-utils/cdn/cdntest"
+)
+
+func staticReferences(filenames ...string) cdn.References {
+	return func(ctx context.Context) iter.Seq2[string, error] {
+		return func(yield func(string, error) bool) {
+			for _, filename := range filenames {
+				if !yield(filename, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{})
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{"a.png", "a_64.png", "orphan.png", "trash/b.png/1760000000", "tmp/c.png"} {
+		srv.PutObject(testBucket, cdntest.S3Object{Key: key, Data: []byte("x"), LastModified: old})
+	}
+	srv.PutObject(testBucket, cdntest.S3Object{Key: "young.png", Data: []byte("x")})
+
+	report, err := cdn.CollectGarbage(context.Background(), c, staticReferences("a.png"), cdn.GCConfig{GracePeriod: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Deleted, []string{"orphan.png"}) {
+		t.Fatalf("deleted %v, want only orphan.png", report.Deleted)
+	}
+	if report.Excluded != 2 || report.Young != 1 || report.Referenced != 2 {
+		t.Fatalf("report %+v", report)
+	}
+
+	report, err = cdn.CollectGarbage(context.Background(), c, staticReferences("a.png"), cdn.GCConfig{GracePeriod: time.Hour, DeleteVariants: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 1 || report.Orphans[0].Filename != "a_64.png" {
+		t.Fatalf("orphans %v, want a_64.png", report.Orphans)
+	}
+
+	if _, err := cdn.CollectGarbage(context.Background(), c, staticReferences(), cdn.GCConfig{}); err == nil {
+		t.Fatal("CollectGarbage ran without a grace period")
+	}
+}
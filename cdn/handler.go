@@ -0,0 +1,225 @@
+package cdn
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultUploadField = "file"
+	// room for the multipart boundaries, headers and small fields sent along with the file
+	multipartOverhead = 1 << 20
+	sniffLen          = 512
+)
+
+var uploadExtRe = regexp.MustCompile(`^[a-z0-9]{1,10}$`)
+
+type UploadHandlerConfig struct {
+	Cdn          Cdn
+	Field        string   // name of the multipart file field, default "file"
+	MaxSize      int64    // bytes, 0 for no limit
+	AllowedTypes []string // checked against the sniffed content, ex: "image/*". Empty allows everything
+	// Reject SVGs with scripts and polyglot files like UploadPolicy.RejectActiveContent, which "image/*"
+	// alone lets through. The whole file is buffered to scan it, so nothing is streamed
+	RejectActiveContent bool
+	// Called after the upload is stored, ex: to save the key on a record. If it returns an error the
+	// object is deleted and the client gets a 500
+	OnUpload func(r *http.Request, res UploadResponse) error
+}
+
+type UploadResponse struct {
+	Key string `json:"key"`
+	URL string `json:"url"`
+}
+
+type uploadErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// Backends that can store a body without holding it in memory, ex: S3Client.
+type streamUploader interface {
+	UploadStream(ctx context.Context, r io.Reader, ext string, contentType string, progress ProgressFunc) (string, error)
+}
+
+type uploadHandler struct {
+	cdn      Cdn
+	field    string
+	policy   UploadPolicy
+	onUpload func(r *http.Request, res UploadResponse) error
+}
+
+// Handler accepting a multipart/form-data POST with a single file in config.Field. The file is
+// stored with the Cdn and the response is JSON {"key": ..., "url": ...} with the URL built by
+// SetImage. Rejected uploads get {"error": ...} with the status from PolicyError.StatusCode.
+// Backends with UploadStream receive the file as it is read from the request, others buffer it.
+// The stored extension is the client's when it matches the sniffed content, otherwise one for the
+// sniffed type.
+func UploadHandler(config UploadHandlerConfig) (http.Handler, error) {
+	if config.Cdn == nil {
+		return nil, fmt.Errorf("cdn is required")
+	}
+	if config.MaxSize < 0 {
+		return nil, fmt.Errorf("max size \"%d\" is invalid: must not be negative", config.MaxSize)
+	}
+	if len(config.Field) == 0 {
+		config.Field = defaultUploadField
+	}
+
+	h := &uploadHandler{
+		cdn:   config.Cdn,
+		field: config.Field,
+		policy: UploadPolicy{
+			MaxSize:             config.MaxSize,
+			AllowedTypes:        config.AllowedTypes,
+			RejectActiveContent: config.RejectActiveContent,
+		},
+		onUpload: config.OnUpload,
+	}
+
+	return h, nil
+}
+
+func writeUploadJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeUploadError(w http.ResponseWriter, status int, msg string) {
+	writeUploadJSON(w, status, uploadErrorResponse{Error: msg})
+}
+
+func (h *uploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeUploadError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	if h.policy.MaxSize > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, h.policy.MaxSize+multipartOverhead)
+	}
+
+	part, err := h.filePart(r)
+	if err != nil {
+		writeUploadError(w, errorStatus(err, http.StatusBadRequest), err.Error())
+		return
+	}
+	defer part.Close()
+
+	key, err := h.store(r, part)
+	if err != nil {
+		// storage errors can leak bucket and endpoint details, only rejections are passed on
+		status := errorStatus(err, http.StatusInternalServerError)
+		if status == http.StatusInternalServerError {
+			writeUploadError(w, status, "upload failed")
+			return
+		}
+		writeUploadError(w, status, err.Error())
+		return
+	}
+
+	res := UploadResponse{Key: key, URL: h.cdn.SetImage(key, "")}
+
+	if h.onUpload != nil {
+		if err := h.onUpload(r, res); err != nil {
+			h.cdn.DeleteFileContext(context.WithoutCancel(r.Context()), key)
+			writeUploadError(w, http.StatusInternalServerError, "upload failed")
+			return
+		}
+	}
+
+	writeUploadJSON(w, http.StatusCreated, res)
+}
+
+// Status for a rejected upload, fallback for anything that isn't a size or policy violation.
+func errorStatus(err error, fallback int) int {
+	var pe *PolicyError
+	if errors.As(err, &pe) {
+		return pe.StatusCode()
+	}
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return fallback
+}
+
+// Advances the multipart reader to the file field, skipping anything before it.
+func (h *uploadHandler) filePart(r *http.Request) (*multipart.Part, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, fmt.Errorf("request must be multipart/form-data")
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return nil, fmt.Errorf("missing file field \"%s\"", h.field)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("read multipart body: %w", err)
+		}
+		if part.FormName() == h.field && len(part.FileName()) > 0 {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// Sniffs the start of the part, checks it against the policy and stores it under a generated filename.
+func (h *uploadHandler) store(r *http.Request, part *multipart.Part) (string, error) {
+	var body io.Reader = part
+	if h.policy.MaxSize > 0 {
+		body = &maxSizeReader{r: body, max: h.policy.MaxSize}
+	}
+
+	br := bufio.NewReaderSize(body, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return "", err
+	}
+	if len(head) == 0 {
+		return "", &PolicyError{Err: ErrEmptyFile, Detail: "0 bytes"}
+	}
+
+	contentType := DetectContentType(head)
+	mt := mediaType(contentType)
+	if !typeAllowed(h.policy.AllowedTypes, mt) {
+		return "", &PolicyError{Err: ErrTypeNotAllowed, Detail: mt}
+	}
+
+	// the client's extension is only kept if it is plain and matches the content, otherwise a PNG
+	// named x.html would be served as HTML by servers that pick the type from the extension
+	ext := strings.ToLower(strings.TrimPrefix(path.Ext(part.FileName()), "."))
+	if !uploadExtRe.MatchString(ext) || !extensionMatches(ext, mt) {
+		var ok bool
+		if ext, ok = typeExtension(mt); !ok {
+			ext = "bin"
+		}
+	}
+
+	if su, ok := h.cdn.(streamUploader); ok && !h.policy.RejectActiveContent {
+		return su.UploadStream(r.Context(), br, ext, contentType, nil)
+	}
+
+	data, err := io.ReadAll(br)
+	if err != nil {
+		return "", err
+	}
+	if h.policy.RejectActiveContent {
+		if detail, ok := hasActiveContent(data, mt); ok {
+			return "", &PolicyError{Err: ErrActiveContent, Detail: detail}
+		}
+	}
+	return h.cdn.UploadFileContext(r.Context(), data, ext, contentType)
+}
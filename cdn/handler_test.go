@@ -0,0 +1,173 @@
+package cdn_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+	"github.com/c-malecki/go-utils/cdn/cdntest"
+)
+
+func newUploadHandler(t *testing.T, config cdn.UploadHandlerConfig) http.Handler {
+	t.Helper()
+	h, err := cdn.UploadHandler(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// POSTs data as the "file" field of a multipart form
+func postFile(t *testing.T, h http.Handler, filename string, data []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "avatar")
+	fw, err := mw.CreateFormFile("file", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func uploadResponse(t *testing.T, w *httptest.ResponseRecorder) cdn.UploadResponse {
+	t.Helper()
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var res cdn.UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestUploadHandlerStreams(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{MultipartPartSize: 5 << 20})
+	h := newUploadHandler(t, cdn.UploadHandlerConfig{Cdn: c, MaxSize: 8 << 20, AllowedTypes: []string{"image/*"}})
+
+	// larger than a part, so it is sent as a multipart upload while the request is read
+	data := append(bytes.Clone(pngMagic), bytes.Repeat([]byte{0}, 6<<20)...)
+	res := uploadResponse(t, postFile(t, h, "avatar.png", data))
+	if !strings.HasSuffix(res.Key, ".png") || !strings.HasSuffix(res.URL, res.Key) {
+		t.Fatalf("response %+v", res)
+	}
+	obj, ok := srv.Object(testBucket, res.Key)
+	if !ok || !bytes.Equal(obj.Data, data) || obj.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("stored %d bytes as %q", len(obj.Data), obj.Header.Get("Content-Type"))
+	}
+
+	// over MaxSize the stream is cut off and the upload aborted
+	data = append(bytes.Clone(pngMagic), bytes.Repeat([]byte{0}, 9<<20)...)
+	if w := postFile(t, h, "avatar.png", data); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d for an oversized stream, want 413: %s", w.Code, w.Body)
+	}
+	if n := srv.PendingUploads(); n != 0 {
+		t.Fatalf("%d multipart uploads left open", n)
+	}
+	if n := len(srv.Objects(testBucket)); n != 1 {
+		t.Fatalf("%d objects stored, want 1", n)
+	}
+}
+
+func TestUploadHandlerTooLarge(t *testing.T) {
+	c := newMemoryClient(t)
+	h := newUploadHandler(t, cdn.UploadHandlerConfig{Cdn: c, MaxSize: 1 << 10})
+
+	// caught by maxSizeReader while reading the part
+	if w := postFile(t, h, "a.txt", bytes.Repeat([]byte("a"), 2<<10)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413: %s", w.Code, w.Body)
+	}
+	// caught by MaxBytesReader before the file part is reached
+	if w := postFile(t, h, "a.txt", bytes.Repeat([]byte("a"), 2<<20)); w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status %d, want 413: %s", w.Code, w.Body)
+	}
+	if n := len(c.Objects()); n != 0 {
+		t.Fatalf("%d objects stored, want 0", n)
+	}
+}
+
+func TestUploadHandlerRewritesExtension(t *testing.T) {
+	c := newMemoryClient(t)
+	h := newUploadHandler(t, cdn.UploadHandlerConfig{Cdn: c})
+
+	for _, tc := range []struct {
+		filename string
+		data     []byte
+		want     string
+	}{
+		{"x.html", pngMagic, ".png"},
+		{"x.PNG", pngMagic, ".png"},
+		{"x", pngMagic, ".png"},
+		{"x.p%ng", pngMagic, ".png"},
+		{"photo.jpeg", jpegMagic, ".jpeg"},
+		{"notes.txt", []byte("plain text"), ".txt"},
+	} {
+		res := uploadResponse(t, postFile(t, h, tc.filename, tc.data))
+		if !strings.HasSuffix(res.Key, tc.want) {
+			t.Fatalf("%s stored as %s, want %s", tc.filename, res.Key, tc.want)
+		}
+	}
+}
+
+func TestUploadHandlerRejectsActiveContent(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{})
+	h := newUploadHandler(t, cdn.UploadHandlerConfig{Cdn: c, AllowedTypes: []string{"image/*"}, RejectActiveContent: true})
+
+	for name, data := range map[string][]byte{
+		"svg with script": []byte(`<svg><script>alert(1)</script></svg>`),
+		"polyglot":        append(bytes.Clone(pngMagic), "<script>alert(1)</script>"...),
+	} {
+		if w := postFile(t, h, "x.svg", data); w.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%s: status %d, want 422: %s", name, w.Code, w.Body)
+		}
+	}
+	if n := len(srv.Objects(testBucket)); n != 0 {
+		t.Fatalf("%d objects stored, want 0", n)
+	}
+
+	res := uploadResponse(t, postFile(t, h, "x.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><path d="M0 0"/></svg>`)))
+	if !strings.HasSuffix(res.Key, ".svg") {
+		t.Fatalf("clean svg stored as %s", res.Key)
+	}
+}
+
+func TestUploadHandlerOnUploadFailure(t *testing.T) {
+	c := newMemoryClient(t)
+	var stored string
+	h := newUploadHandler(t, cdn.UploadHandlerConfig{
+		Cdn: c,
+		OnUpload: func(r *http.Request, res cdn.UploadResponse) error {
+			stored = res.Key
+			return errors.New("db down")
+		},
+	})
+
+	w := postFile(t, h, "a.png", pngMagic)
+	if w.Code != http.StatusInternalServerError || strings.Contains(w.Body.String(), "db down") {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	if len(stored) == 0 {
+		t.Fatal("OnUpload not called")
+	}
+	if _, ok := c.Object(stored); ok {
+		t.Fatalf("%s kept after OnUpload failed", stored)
+	}
+}
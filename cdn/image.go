@@ -0,0 +1,179 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/c-malecki/go-utils/img/exif"
+	"github.com/c-malecki/go-utils/img/resize"
+	_ "golang.org/x/image/webp"
+)
+
+type ResizeMode int
+
+const (
+	ResizeFit    ResizeMode = iota // longest side scaled to Size, aspect ratio kept
+	ResizeSquare                   // centered square crop scaled to Size x Size
+)
+
+type ImageVariant struct {
+	Name string // ex: "thumb", used in the variant filename
+	Size int    // pixels, images are never upscaled
+	Mode ResizeMode
+}
+
+type ImageUpload struct {
+	Filename string            // original, as returned by UploadFile
+	Variants map[string]string // variant name -> filename
+}
+
+var DefaultImageVariants = []ImageVariant{
+	{Name: "64", Size: 64, Mode: ResizeSquare},
+	{Name: "256", Size: 256, Mode: ResizeSquare},
+	{Name: "1024", Size: 1024, Mode: ResizeFit},
+}
+
+const jpegQuality = 85
+
+// Largest width * height decoded from an upload. Compressed files of a few KB can declare
+// dimensions that take gigabytes to decode, so larger images are rejected before decoding.
+// ex: 8000x5000, 0 for no limit
+var MaxImagePixels int64 = 40_000_000
+
+var variantNameRe = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+// Derives the filename a variant of an uploaded image is stored under.
+// ex: 1760000000-a1b2c3d4e5f6.jpg + thumb -> 1760000000-a1b2c3d4e5f6_thumb.jpg
+// Variants of JPEG originals are JPEG, every other format is stored as PNG.
+func VariantFilename(filename string, variant string) string {
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext)
+	return base + "_" + variant + variantExt(ext)
+}
+
+func variantExt(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".jpg", ".jpeg":
+		return ext
+	default:
+		return ".png"
+	}
+}
+
+// Like SetImage but resolves the URL of a variant created by UploadImage.
+func SetImageVariant(c Cdn, imageFile string, variant string, name string) string {
+	if len(imageFile) == 0 || strings.HasPrefix(imageFile, "data:") {
+		return c.SetImage(imageFile, name)
+	}
+	return c.SetImage(VariantFilename(imageFile, variant), name)
+}
+
+// Decodes a JPEG, PNG, GIF or WebP image, uploads the original with UploadFile and every variant
+// under a filename derived with VariantFilename. If any upload fails the files already uploaded
+// are deleted. Images over MaxImagePixels are rejected before they are decoded.
+func UploadImage(ctx context.Context, c Cdn, data []byte, ext string, variants []ImageVariant) (ImageUpload, error) {
+	for _, v := range variants {
+		if !variantNameRe.MatchString(v.Name) {
+			return ImageUpload{}, fmt.Errorf("variant name \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 -\" only", v.Name)
+		}
+		if v.Size <= 0 {
+			return ImageUpload{}, fmt.Errorf("variant \"%s\" size %d is invalid: must be positive", v.Name, v.Size)
+		}
+	}
+
+	if err := checkImageSize(data); err != nil {
+		return ImageUpload{}, err
+	}
+
+	src, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return ImageUpload{}, fmt.Errorf("image.Decode %w", err)
+	}
+	// variants carry no EXIF, so they are rotated upright like the original is when stripped
+	src = exif.Orient(src, exif.Orientation(data))
+
+	filename, err := c.UploadFileContext(ctx, data, ext, "image/"+format)
+	if err != nil {
+		return ImageUpload{}, err
+	}
+
+	upload := ImageUpload{
+		Filename: filename,
+		Variants: make(map[string]string, len(variants)),
+	}
+
+	for _, v := range variants {
+		variantFile := VariantFilename(filename, v.Name)
+
+		if err := uploadVariant(ctx, c, src, variantFile, v); err != nil {
+			// ctx may be why the upload failed, the cleanup still has to go out
+			cleanupErr := DeleteImage(context.WithoutCancel(ctx), c, upload)
+			return ImageUpload{}, errors.Join(fmt.Errorf("variant \"%s\": %w", v.Name, err), cleanupErr)
+		}
+
+		upload.Variants[v.Name] = variantFile
+	}
+
+	return upload, nil
+}
+
+// Reads the dimensions from the image header and rejects images over MaxImagePixels
+// with a *PolicyError wrapping ErrFileTooLarge.
+func checkImageSize(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("image.DecodeConfig %w", err)
+	}
+	if pixels := int64(config.Width) * int64(config.Height); MaxImagePixels > 0 && pixels > MaxImagePixels {
+		return &PolicyError{Err: ErrFileTooLarge, Detail: fmt.Sprintf("%dx%d image exceeds %d pixels", config.Width, config.Height, MaxImagePixels)}
+	}
+	return nil
+}
+
+func uploadVariant(ctx context.Context, c Cdn, src image.Image, filename string, v ImageVariant) error {
+	var dst image.Image
+	switch v.Mode {
+	case ResizeSquare:
+		dst = resize.Square(src, v.Size)
+	default:
+		dst = resize.Fit(src, v.Size)
+	}
+
+	var buf bytes.Buffer
+	contentType := "image/png"
+	if strings.HasSuffix(filename, ".png") {
+		if err := png.Encode(&buf, dst); err != nil {
+			return err
+		}
+	} else {
+		contentType = "image/jpeg"
+		if err := jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return err
+		}
+	}
+
+	return c.PutFileContext(ctx, filename, buf.Bytes(), contentType)
+}
+
+// Deletes the original and every variant of an upload.
+func DeleteImage(ctx context.Context, c Cdn, upload ImageUpload) error {
+	var errs []error
+	for _, filename := range upload.Variants {
+		if err := c.DeleteFileContext(ctx, filename); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := c.DeleteFileContext(ctx, upload.Filename); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,107 @@
+package cdn
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/c-malecki/go-utils/gen"
+)
+
+// Names new uploads. The filename returned is relative to PublicPrefix, it is what UploadFile
+// returns, what DeleteFile and URL take and what gets stored in the DB.
+type KeyStrategy interface {
+	// data is nil for streamed uploads whose content isn't known upfront
+	Filename(ctx context.Context, data []byte, ext string) (string, error)
+}
+
+// Adapts a function to KeyStrategy.
+type KeyFunc func(ctx context.Context, data []byte, ext string) (string, error)
+
+func (f KeyFunc) Filename(ctx context.Context, data []byte, ext string) (string, error) {
+	return f(ctx, data, ext)
+}
+
+var (
+	// Unix seconds and 12 random hex characters, ex: 1760745600-3f9a1c2b7d4e.png. The default
+	UniqueKeys KeyStrategy = KeyFunc(func(ctx context.Context, data []byte, ext string) (string, error) {
+		return gen.GenerateUniqueFilename(ext), nil
+	})
+	// ex: 019a2f4e-7c3b-7d2a-9f1e-3b5c8a0d4e6f.png
+	UUIDv7Keys KeyStrategy = KeyFunc(func(ctx context.Context, data []byte, ext string) (string, error) {
+		return gen.GenerateUUIDv7() + "." + ext, nil
+	})
+	// ex: 01K8QWX5S2M3ZP7T4R9B6N1C0D.png
+	ULIDKeys KeyStrategy = KeyFunc(func(ctx context.Context, data []byte, ext string) (string, error) {
+		return gen.GenerateULID() + "." + ext, nil
+	})
+	// SHA-256 of the content so identical files share a key. Can't name streamed uploads, which
+	// includes UploadStream, PresignUpload and UploadHandler with S3Client
+	ContentHashKeys KeyStrategy = KeyFunc(func(ctx context.Context, data []byte, ext string) (string, error) {
+		if data == nil {
+			return "", fmt.Errorf("content hash keys need the whole file, streamed uploads can't use them")
+		}
+		return gen.GenerateContentFilename(data, ext), nil
+	})
+)
+
+// Puts the filenames of next under the UTC date of the upload, ex: 2026/10/18/1760745600-3f9a1c2b7d4e.png
+func DateKeys(next KeyStrategy) KeyStrategy {
+	return KeyFunc(func(ctx context.Context, data []byte, ext string) (string, error) {
+		filename, err := next.Filename(ctx, data, ext)
+		if err != nil {
+			return "", err
+		}
+		return time.Now().UTC().Format("2006/01/02") + "/" + filename, nil
+	})
+}
+
+type tenantKey struct{}
+
+// a single path segment, so one tenant can't name a directory inside another's
+var tenantRe = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Sets the tenant TenantKeys puts uploads made with ctx under.
+func WithTenant(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, id)
+}
+
+func TenantFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(tenantKey{}).(string)
+	return id, ok && len(id) > 0
+}
+
+// Puts the filenames of next under the tenant set with WithTenant, ex: tenant/42/1760745600-3f9a1c2b7d4e.png
+// Uploads without a tenant fail rather than landing outside every tenant's directory.
+func TenantKeys(next KeyStrategy) KeyStrategy {
+	return KeyFunc(func(ctx context.Context, data []byte, ext string) (string, error) {
+		id, ok := TenantFromContext(ctx)
+		if !ok {
+			return "", fmt.Errorf("tenant keys need a tenant, set it with WithTenant")
+		}
+		if !tenantRe.MatchString(id) {
+			return "", fmt.Errorf("tenant \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 _ -\" only", id)
+		}
+		filename, err := next.Filename(ctx, data, ext)
+		if err != nil {
+			return "", err
+		}
+		return "tenant/" + id + "/" + filename, nil
+	})
+}
+
+// Names an upload with keys, UniqueKeys when nil, and checks the result is a valid filename.
+func newFilename(ctx context.Context, keys KeyStrategy, data []byte, ext string) (string, error) {
+	if keys == nil {
+		keys = UniqueKeys
+	}
+	filename, err := keys.Filename(ctx, data, ext)
+	if err != nil {
+		return "", err
+	}
+	if err := validateFilename(filename); err != nil {
+		return "", fmt.Errorf("key strategy: %w", err)
+	}
+	return filename, nil
+}
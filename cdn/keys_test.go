@@ -0,0 +1,43 @@
+package cdn_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+)
+
+func TestTenantKeys(t *testing.T) {
+	keys := cdn.TenantKeys(cdn.UniqueKeys)
+
+	filename, err := keys.Filename(cdn.WithTenant(context.Background(), "acme_1-2"), nil, "png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(filename, "tenant/acme_1-2/") || strings.Count(filename, "/") != 2 {
+		t.Fatalf("filename %s", filename)
+	}
+
+	for _, id := range []string{"", "1/evil", "..", "a b", "../2"} {
+		if filename, err := keys.Filename(cdn.WithTenant(context.Background(), id), nil, "png"); err == nil {
+			t.Fatalf("tenant %q named %s", id, filename)
+		}
+	}
+	if _, err := keys.Filename(context.Background(), nil, "png"); err == nil {
+		t.Fatal("named an upload without a tenant")
+	}
+}
+
+func TestTenantKeysUpload(t *testing.T) {
+	c, err := cdn.CreateMemoryClient(cdn.MemoryClientConfig{KeyStrategy: cdn.TenantKeys(cdn.UniqueKeys)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.UploadFileContext(cdn.WithTenant(context.Background(), "1/evil"), []byte("x"), "txt", "text/plain"); err == nil {
+		t.Fatal("uploaded under a tenant with a slash")
+	}
+	if n := len(c.Objects()); n != 0 {
+		t.Fatalf("%d objects stored, want 0", n)
+	}
+}
@@ -0,0 +1,72 @@
+package cdn
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"iter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+type ObjectInfo struct {
+	Filename     string // relative to PublicPrefix, as UploadFile returns it
+	Size         int64
+	ETag         string // without quotes, for multipart uploads not the MD5 of the content
+	LastModified time.Time
+}
+
+// Iterates every object whose filename starts with prefix, "" for everything under PublicPrefix,
+// paging through ListObjectsV2 as it goes. Iteration stops after the first error.
+//
+//	for obj, err := range client.ListFiles(ctx, "exports/") {
+//		if err != nil {
+//			return err
+//		}
+//		...
+//	}
+func (c *S3Client) ListFiles(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		// with a public prefix only keys below "prefix/" belong to this client
+		paginator := s3.NewListObjectsV2Paginator(c.s3, &s3.ListObjectsV2Input{
+			Bucket: aws.String(c.bucket),
+			Prefix: aws.String(c.objectKey(prefix)),
+		})
+		for paginator.HasMorePages() {
+			reqCtx, cancel := c.withTimeout(ctx)
+			page, err := paginator.NextPage(reqCtx)
+			cancel()
+			if err != nil {
+				yield(ObjectInfo{}, err)
+				return
+			}
+
+			for _, obj := range page.Contents {
+				info := ObjectInfo{
+					Filename:     c.filename(aws.ToString(obj.Key)),
+					Size:         aws.ToInt64(obj.Size),
+					ETag:         trimETag(aws.ToString(obj.ETag)),
+					LastModified: aws.ToTime(obj.LastModified),
+				}
+				if !yield(info, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// S3 ETag of an object uploaded in a single part
+func md5Hex(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func trimETag(etag string) string {
+	if len(etag) >= 2 && etag[0] == '"' && etag[len(etag)-1] == '"' {
+		return etag[1 : len(etag)-1]
+	}
+	return etag
+}
@@ -0,0 +1,361 @@
+package cdn
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+type LocalClientConfig struct {
+	PublicURL    string // ex: http://localhost:8080/cdn/
+	PublicPrefix string // ex: dev -> files are written to RootDir/dev/
+	RootDir      string // ex: ./tmp/cdn
+	UploadPolicy *UploadPolicy
+	KeyStrategy  KeyStrategy // default UniqueKeys
+}
+
+// Disk backed Cdn for development and CI where no bucket is available.
+// Objects are stored under RootDir using the same keys S3Client would use.
+type LocalClient struct {
+	root   string
+	url    string
+	prefix string
+	policy *UploadPolicy
+	keys   KeyStrategy
+
+	mu    sync.Mutex
+	etags map[string]localETag // file path -> MD5, see etag
+}
+
+type localETag struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+var _ Cdn = (*LocalClient)(nil)
+
+func CreateLocalClient(config LocalClientConfig) (*LocalClient, error) {
+	if len(config.PublicPrefix) > 0 && !validateS3Key(config.PublicPrefix) {
+		return nil, fmt.Errorf("public prefix \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 _ -\" only", config.PublicPrefix)
+	}
+	if len(config.RootDir) == 0 {
+		return nil, fmt.Errorf("root dir is required")
+	}
+
+	root, err := filepath.Abs(config.RootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Join(root, filepath.FromSlash(config.PublicPrefix)), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create root dir %s: %w", root, err)
+	}
+
+	client := &LocalClient{
+		root:   root,
+		url:    config.PublicURL,
+		prefix: config.PublicPrefix,
+		policy: config.UploadPolicy,
+		keys:   config.KeyStrategy,
+		etags:  make(map[string]localETag),
+	}
+
+	return client, nil
+}
+
+func (c *LocalClient) objectPath(filename string) (string, error) {
+	key := joinPrefix(c.prefix, filename)
+	path := filepath.Join(c.root, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, c.root+string(filepath.Separator)) {
+		return "", fmt.Errorf("object key \"%s\" escapes root dir", key)
+	}
+	return path, nil
+}
+
+func (c *LocalClient) SetImage(imageFile string, name string) string {
+	return publicImage(c.url, imageFile, name)
+}
+
+func (c *LocalClient) UploadFile(data []byte, ext string, contentType string) (string, error) {
+	return c.UploadFileContext(context.Background(), data, ext, contentType)
+}
+
+func (c *LocalClient) UploadFileContext(ctx context.Context, data []byte, ext string, contentType string) (string, error) {
+	filename, err := newFilename(ctx, c.keys, data, ext)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.PutFileContext(ctx, filename, data, contentType); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+func (c *LocalClient) PutFile(filename string, data []byte, contentType string) error {
+	return c.PutFileContext(context.Background(), filename, data, contentType)
+}
+
+func (c *LocalClient) PutFileContext(ctx context.Context, filename string, data []byte, contentType string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := validateFilename(filename); err != nil {
+		return err
+	}
+
+	data, _, err := checkUpload(c.policy, data, filename, contentType)
+	if err != nil {
+		return err
+	}
+
+	path, err := c.objectPath(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Like S3 deleting an object that does not exist is not an error
+func (c *LocalClient) DeleteFile(filename string) error {
+	return c.DeleteFileContext(context.Background(), filename)
+}
+
+func (c *LocalClient) DeleteFileContext(ctx context.Context, filename string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	path, err := c.objectPath(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	c.mu.Lock()
+	delete(c.etags, path)
+	c.mu.Unlock()
+
+	return nil
+}
+
+// Serves stored objects by the filenames returned from UploadFile, so it should be
+// mounted at the path of PublicURL.
+// ex: mux.Handle("/cdn/", http.StripPrefix("/cdn", client.Handler()))
+func (c *LocalClient) Handler() http.Handler {
+	fs := http.FileServer(http.Dir(filepath.Join(c.root, filepath.FromSlash(c.prefix))))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		fs.ServeHTTP(w, r)
+	})
+}
+
+func (c *LocalClient) ListFiles(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		root := filepath.Join(c.root, filepath.FromSlash(c.prefix))
+
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			filename := filepath.ToSlash(rel)
+			if !strings.HasPrefix(filename, prefix) {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			etag, err := c.etag(path, info)
+			if err != nil {
+				return err
+			}
+
+			obj := ObjectInfo{
+				Filename:     filename,
+				Size:         info.Size(),
+				ETag:         etag,
+				LastModified: info.ModTime(),
+			}
+			if !yield(obj, nil) {
+				return fs.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			yield(ObjectInfo{}, err)
+		}
+	}
+}
+
+func (c *LocalClient) GetFile(ctx context.Context, filename string) (io.ReadCloser, ObjectMetadata, error) {
+	f, meta, err := c.open(ctx, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+	return f, meta, nil
+}
+
+func (c *LocalClient) GetFileRange(ctx context.Context, filename string, offset int64, length int64) (io.ReadCloser, ObjectMetadata, error) {
+	if err := validateRange(offset, length); err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	f, meta, err := c.open(ctx, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	n, err := rangeLength(offset, length, meta.Size)
+	if err != nil {
+		f.Close()
+		return nil, ObjectMetadata{}, err
+	}
+
+	return closeFunc{Reader: io.NewSectionReader(f, offset, n), close: f.Close}, meta, nil
+}
+
+func (c *LocalClient) HeadFile(ctx context.Context, filename string) (ObjectMetadata, error) {
+	f, meta, err := c.open(ctx, filename)
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+	f.Close()
+	return meta, nil
+}
+
+// Opens the file for filename and reads its metadata. The ETag is the MD5 of the content like
+// ListFiles reports it and the content type is sniffed since nothing else is stored.
+func (c *LocalClient) open(ctx context.Context, filename string) (*os.File, ObjectMetadata, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	path, err := c.objectPath(filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ObjectMetadata{}, notFound(joinPrefix(c.prefix, filename))
+	}
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	info, err := f.Stat()
+	if err == nil && info.IsDir() {
+		err = notFound(joinPrefix(c.prefix, filename))
+	}
+	if err != nil {
+		f.Close()
+		return nil, ObjectMetadata{}, err
+	}
+
+	var meta ObjectMetadata
+	etag, err := c.etag(path, info)
+	if err == nil {
+		meta, err = localMetadata(f, info, filename, etag)
+	}
+	if err != nil {
+		f.Close()
+		return nil, ObjectMetadata{}, err
+	}
+
+	return f, meta, nil
+}
+
+// Reads the start of f to sniff its content type and seeks back to the start.
+func localMetadata(f *os.File, info fs.FileInfo, filename string, etag string) (ObjectMetadata, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return ObjectMetadata{}, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return ObjectMetadata{}, err
+	}
+
+	meta := ObjectMetadata{
+		ObjectInfo: ObjectInfo{
+			Filename:     filename,
+			Size:         info.Size(),
+			ETag:         etag,
+			LastModified: info.ModTime(),
+		},
+		ContentType: DetectContentType(head[:n]),
+	}
+
+	return meta, nil
+}
+
+// MD5 of the file at path, cached by size and modification time so listing and HEAD requests
+// only read files that changed since they were last hashed.
+func (c *LocalClient) etag(path string, info fs.FileInfo) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.etags[path]
+	c.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.etag, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	etag := hex.EncodeToString(h.Sum(nil))
+
+	c.mu.Lock()
+	c.etags[path] = localETag{size: info.Size(), modTime: info.ModTime(), etag: etag}
+	c.mu.Unlock()
+
+	return etag, nil
+}
@@ -0,0 +1,388 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrInjectedFault = errors.New("cdn: injected fault")
+
+type MemoryOp string
+
+const (
+	MemoryOpUpload MemoryOp = "upload" // UploadFile and PutFile
+	MemoryOpDelete MemoryOp = "delete"
+	MemoryOpRead   MemoryOp = "read" // GetFile, GetFileRange and HeadFile
+)
+
+type MemoryObject struct {
+	Filename    string // returned by UploadFile
+	Key         string // Filename joined to PublicPrefix
+	Data        []byte
+	ContentType string
+	Uploaded    time.Time
+}
+
+type MemoryCall struct {
+	Op          MemoryOp
+	N           int // 1 based count of calls for Op
+	Filename    string
+	Key         string
+	Data        []byte // upload only
+	ContentType string // upload only, after detection
+	Err         error
+}
+
+type MemoryClientConfig struct {
+	PublicURL    string
+	PublicPrefix string
+	UploadPolicy *UploadPolicy
+	KeyStrategy  KeyStrategy // default UniqueKeys
+}
+
+type memoryFault struct {
+	op  MemoryOp
+	n   int
+	err error
+}
+
+// In memory Cdn for unit tests. Every call is recorded and can be inspected
+// with Calls and Objects, and failures or latency can be injected to cover
+// retry and cleanup paths. Safe for concurrent use.
+type MemoryClient struct {
+	mu      sync.Mutex
+	url     string
+	prefix  string
+	objects map[string]MemoryObject
+	calls   []MemoryCall
+	counts  map[MemoryOp]int
+	faults  []memoryFault
+	latency time.Duration
+	policy  *UploadPolicy
+	keys    KeyStrategy
+}
+
+var _ Cdn = (*MemoryClient)(nil)
+
+func CreateMemoryClient(config MemoryClientConfig) (*MemoryClient, error) {
+	if len(config.PublicPrefix) > 0 && !validateS3Key(config.PublicPrefix) {
+		return nil, fmt.Errorf("public prefix \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 _ -\" only", config.PublicPrefix)
+	}
+
+	client := &MemoryClient{
+		url:     config.PublicURL,
+		prefix:  config.PublicPrefix,
+		objects: make(map[string]MemoryObject),
+		counts:  make(map[MemoryOp]int),
+		policy:  config.UploadPolicy,
+		keys:    config.KeyStrategy,
+	}
+
+	return client, nil
+}
+
+// Makes the nth (1 based, counted since creation or Reset) call of op fail with err.
+// A nil err fails with ErrInjectedFault.
+func (c *MemoryClient) FailNth(op MemoryOp, n int, err error) {
+	if err == nil {
+		err = ErrInjectedFault
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.faults = append(c.faults, memoryFault{op: op, n: n, err: err})
+}
+
+// Makes every following call of op fail with err until Reset.
+// A nil err fails with ErrInjectedFault.
+func (c *MemoryClient) FailAll(op MemoryOp, err error) {
+	c.FailNth(op, 0, err)
+}
+
+// Delays every call by d before it is handled, or until its context is done.
+func (c *MemoryClient) SetLatency(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.latency = d
+}
+
+// Clears stored objects, recorded calls, injected faults and latency.
+func (c *MemoryClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.objects = make(map[string]MemoryObject)
+	c.counts = make(map[MemoryOp]int)
+	c.calls = nil
+	c.faults = nil
+	c.latency = 0
+}
+
+// Returns every recorded call in the order it was made.
+func (c *MemoryClient) Calls() []MemoryCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Clone(c.calls)
+}
+
+// Returns recorded calls of op in the order they were made.
+func (c *MemoryClient) CallsOf(op MemoryOp) []MemoryCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var calls []MemoryCall
+	for _, call := range c.calls {
+		if call.Op == op {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Returns stored objects sorted by key.
+func (c *MemoryClient) Objects() []MemoryObject {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	objects := make([]MemoryObject, 0, len(c.objects))
+	for _, obj := range c.objects {
+		objects = append(objects, obj)
+	}
+	slices.SortFunc(objects, func(a, b MemoryObject) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return objects
+}
+
+// Returns the stored object for a filename returned by UploadFile.
+func (c *MemoryClient) Object(filename string) (MemoryObject, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj, ok := c.objects[joinPrefix(c.prefix, filename)]
+	return obj, ok
+}
+
+// Counts the call, waits out any latency and returns the injected fault for it if there is one.
+// Caller must not hold mu.
+func (c *MemoryClient) begin(ctx context.Context, op MemoryOp) (int, error) {
+	c.mu.Lock()
+	c.counts[op]++
+	n := c.counts[op]
+	latency := c.latency
+	var err error
+	for _, f := range c.faults {
+		if f.op == op && (f.n == 0 || f.n == n) {
+			err = f.err
+			break
+		}
+	}
+	c.mu.Unlock()
+
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+		}
+	}
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return n, ctxErr
+	}
+
+	return n, err
+}
+
+func (c *MemoryClient) SetImage(imageFile string, name string) string {
+	return publicImage(c.url, imageFile, name)
+}
+
+func (c *MemoryClient) UploadFile(data []byte, ext string, contentType string) (string, error) {
+	return c.UploadFileContext(context.Background(), data, ext, contentType)
+}
+
+func (c *MemoryClient) UploadFileContext(ctx context.Context, data []byte, ext string, contentType string) (string, error) {
+	filename, err := newFilename(ctx, c.keys, data, ext)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.PutFileContext(ctx, filename, data, contentType); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+func (c *MemoryClient) PutFile(filename string, data []byte, contentType string) error {
+	return c.PutFileContext(context.Background(), filename, data, contentType)
+}
+
+func (c *MemoryClient) PutFileContext(ctx context.Context, filename string, data []byte, contentType string) error {
+	if err := validateFilename(filename); err != nil {
+		return err
+	}
+
+	n, err := c.begin(ctx, MemoryOpUpload)
+
+	key := joinPrefix(c.prefix, filename)
+
+	// injected faults take precedence over policy violations
+	if checkedData, checked, checkErr := checkUpload(c.policy, data, filename, contentType); checkErr != nil && err == nil {
+		err = checkErr
+	} else if checkErr == nil {
+		data, contentType = checkedData, checked
+	}
+
+	data = slices.Clone(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, MemoryCall{
+		Op:          MemoryOpUpload,
+		N:           n,
+		Filename:    filename,
+		Key:         key,
+		Data:        data,
+		ContentType: contentType,
+		Err:         err,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.objects[key] = MemoryObject{
+		Filename:    filename,
+		Key:         key,
+		Data:        data,
+		ContentType: contentType,
+		Uploaded:    time.Now(),
+	}
+
+	return nil
+}
+
+func (c *MemoryClient) DeleteFile(filename string) error {
+	return c.DeleteFileContext(context.Background(), filename)
+}
+
+func (c *MemoryClient) DeleteFileContext(ctx context.Context, filename string) error {
+	n, err := c.begin(ctx, MemoryOpDelete)
+
+	key := joinPrefix(c.prefix, filename)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.calls = append(c.calls, MemoryCall{
+		Op:       MemoryOpDelete,
+		N:        n,
+		Filename: filename,
+		Key:      key,
+		Err:      err,
+	})
+	if err != nil {
+		return err
+	}
+
+	delete(c.objects, key)
+
+	return nil
+}
+
+func (c *MemoryClient) ListFiles(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		for _, obj := range c.Objects() {
+			if err := ctx.Err(); err != nil {
+				yield(ObjectInfo{}, err)
+				return
+			}
+			if !strings.HasPrefix(obj.Filename, prefix) {
+				continue
+			}
+			info := ObjectInfo{
+				Filename:     obj.Filename,
+				Size:         int64(len(obj.Data)),
+				ETag:         md5Hex(obj.Data),
+				LastModified: obj.Uploaded,
+			}
+			if !yield(info, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (c *MemoryClient) GetFile(ctx context.Context, filename string) (io.ReadCloser, ObjectMetadata, error) {
+	data, meta, err := c.read(ctx, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), meta, nil
+}
+
+func (c *MemoryClient) GetFileRange(ctx context.Context, filename string, offset int64, length int64) (io.ReadCloser, ObjectMetadata, error) {
+	if err := validateRange(offset, length); err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	data, meta, err := c.read(ctx, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	n, err := rangeLength(offset, length, meta.Size)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data[offset : offset+n])), meta, nil
+}
+
+func (c *MemoryClient) HeadFile(ctx context.Context, filename string) (ObjectMetadata, error) {
+	_, meta, err := c.read(ctx, filename)
+	return meta, err
+}
+
+// Records a read call and returns a copy of the object's data.
+func (c *MemoryClient) read(ctx context.Context, filename string) ([]byte, ObjectMetadata, error) {
+	n, err := c.begin(ctx, MemoryOpRead)
+
+	key := joinPrefix(c.prefix, filename)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[key]
+	if err == nil && !ok {
+		err = notFound(key)
+	}
+
+	c.calls = append(c.calls, MemoryCall{
+		Op:       MemoryOpRead,
+		N:        n,
+		Filename: filename,
+		Key:      key,
+		Err:      err,
+	})
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	meta := ObjectMetadata{
+		ObjectInfo: ObjectInfo{
+			Filename:     filename,
+			Size:         int64(len(obj.Data)),
+			ETag:         md5Hex(obj.Data),
+			LastModified: obj.Uploaded,
+		},
+		ContentType: obj.ContentType,
+	}
+
+	return slices.Clone(obj.Data), meta, nil
+}
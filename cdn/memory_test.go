@@ -0,0 +1,84 @@
+package cdn_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c-malecki/go-utils/cdn"
+)
+
+func newMemoryClient(t *testing.T) *cdn.MemoryClient {
+	t.Helper()
+	c, err := cdn.CreateMemoryClient(cdn.MemoryClientConfig{PublicURL: "https://cdn.example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMemoryFailNth(t *testing.T) {
+	c := newMemoryClient(t)
+	c.FailNth(cdn.MemoryOpUpload, 2, nil)
+
+	for i, filename := range []string{"a.txt", "b.txt", "c.txt"} {
+		err := c.PutFile(filename, []byte("x"), "text/plain")
+		if i == 1 && !errors.Is(err, cdn.ErrInjectedFault) {
+			t.Fatalf("upload 2 = %v, want ErrInjectedFault", err)
+		}
+		if i != 1 && err != nil {
+			t.Fatalf("upload %d = %v", i+1, err)
+		}
+	}
+
+	if _, ok := c.Object("b.txt"); ok {
+		t.Fatal("failed upload was stored")
+	}
+	calls := c.CallsOf(cdn.MemoryOpUpload)
+	if len(calls) != 3 || calls[1].N != 2 || !errors.Is(calls[1].Err, cdn.ErrInjectedFault) {
+		t.Fatalf("recorded %+v", calls)
+	}
+}
+
+func TestMemoryFailAll(t *testing.T) {
+	c := newMemoryClient(t)
+	if err := c.PutFile("a.txt", []byte("x"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	errGone := errors.New("gone")
+	c.FailAll(cdn.MemoryOpDelete, errGone)
+	for range 2 {
+		if err := c.DeleteFile("a.txt"); !errors.Is(err, errGone) {
+			t.Fatalf("delete = %v, want the injected error", err)
+		}
+	}
+	if _, ok := c.Object("a.txt"); !ok {
+		t.Fatal("object deleted despite the fault")
+	}
+
+	// faults of other ops are unaffected
+	if _, err := c.HeadFile(context.Background(), "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Reset()
+	if err := c.DeleteFile("a.txt"); err != nil {
+		t.Fatalf("delete after Reset = %v", err)
+	}
+	if len(c.Calls()) != 1 {
+		t.Fatalf("%d calls recorded after Reset, want 1", len(c.Calls()))
+	}
+}
+
+func TestMemoryLatencyHonorsContext(t *testing.T) {
+	c := newMemoryClient(t)
+	c.SetLatency(time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.PutFileContext(ctx, "a.txt", []byte("x"), "text/plain"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("upload = %v, want context.DeadlineExceeded", err)
+	}
+}
@@ -0,0 +1,392 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"sync"
+)
+
+type MirrorWritePolicy int
+
+const (
+	// Writes fail unless every backend succeeds, uploads that succeeded on some backends are rolled back
+	MirrorWriteAll MirrorWritePolicy = iota
+	// Writes fail only if the primary fails, secondary failures are passed to OnSecondaryError
+	MirrorWritePrimary
+)
+
+type MirrorConfig struct {
+	Primary     Cdn // SetImage builds URLs with it and reads go to it first
+	Secondaries []Cdn
+	WritePolicy MirrorWritePolicy
+	// Called for failed secondary writes under MirrorWritePrimary, ex: to log them for a later Backfill.
+	// index is the position in Secondaries
+	OnSecondaryError func(index int, op string, filename string, err error)
+	KeyStrategy      KeyStrategy // names uploads for every backend, default UniqueKeys
+}
+
+// Cdn that writes to several backends, for migrating from one provider to another.
+// Uploads and deletes go to every backend concurrently, URLs come from the primary and reads and listing
+// fall back to the secondaries in order if the primary fails.
+type MirrorClient struct {
+	primary     Cdn
+	secondaries []Cdn
+	policy      MirrorWritePolicy
+	onError     func(index int, op string, filename string, err error)
+	keys        KeyStrategy
+}
+
+var _ Cdn = (*MirrorClient)(nil)
+
+func CreateMirrorClient(config MirrorConfig) (*MirrorClient, error) {
+	if config.Primary == nil {
+		return nil, fmt.Errorf("primary is required")
+	}
+
+	client := &MirrorClient{
+		primary:     config.Primary,
+		secondaries: config.Secondaries,
+		policy:      config.WritePolicy,
+		onError:     config.OnSecondaryError,
+		keys:        config.KeyStrategy,
+	}
+
+	return client, nil
+}
+
+// Primary first, then the secondaries in order.
+func (c *MirrorClient) backends() []Cdn {
+	return append([]Cdn{c.primary}, c.secondaries...)
+}
+
+// Runs fn against every backend concurrently and returns one error per backend, primary first.
+func (c *MirrorClient) each(fn func(Cdn) error) []error {
+	backends := c.backends()
+	errs := make([]error, len(backends))
+
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Go(func() {
+			errs[i] = fn(b)
+		})
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// Applies the write policy to the per backend errors of each.
+func (c *MirrorClient) writeResult(op string, filename string, errs []error) error {
+	if c.policy == MirrorWritePrimary {
+		for i, err := range errs[1:] {
+			if err != nil && c.onError != nil {
+				c.onError(i, op, filename, err)
+			}
+		}
+		return errs[0]
+	}
+
+	var joined []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		if i == 0 {
+			joined = append(joined, fmt.Errorf("primary: %w", err))
+		} else {
+			joined = append(joined, fmt.Errorf("secondary %d: %w", i-1, err))
+		}
+	}
+	return errors.Join(joined...)
+}
+
+func (c *MirrorClient) SetImage(imageFile string, name string) string {
+	return c.primary.SetImage(imageFile, name)
+}
+
+func (c *MirrorClient) UploadFile(data []byte, ext string, contentType string) (string, error) {
+	return c.UploadFileContext(context.Background(), data, ext, contentType)
+}
+
+// The filename is generated once so every backend stores the object under the same key.
+func (c *MirrorClient) UploadFileContext(ctx context.Context, data []byte, ext string, contentType string) (string, error) {
+	filename, err := newFilename(ctx, c.keys, data, ext)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.PutFileContext(ctx, filename, data, contentType); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+func (c *MirrorClient) PutFile(filename string, data []byte, contentType string) error {
+	return c.PutFileContext(context.Background(), filename, data, contentType)
+}
+
+// Under MirrorWriteAll a failed put is rolled back by deleting filename from the backends it was
+// uploaded to, objects that existed there before the call are left as they are.
+func (c *MirrorClient) PutFileContext(ctx context.Context, filename string, data []byte, contentType string) error {
+	var existed []bool
+	if c.policy == MirrorWriteAll {
+		existed = c.existing(ctx, filename)
+	}
+
+	errs := c.each(func(b Cdn) error {
+		return b.PutFileContext(ctx, filename, data, contentType)
+	})
+
+	err := c.writeResult("put", filename, errs)
+	if err == nil || c.policy == MirrorWritePrimary {
+		return err
+	}
+
+	// an object missing from some backends is worse than a failed upload the caller can retry
+	rollback := context.WithoutCancel(ctx)
+	for i, b := range c.backends() {
+		if errs[i] == nil && !existed[i] {
+			if deleteErr := b.DeleteFileContext(rollback, filename); deleteErr != nil {
+				err = errors.Join(err, fmt.Errorf("rollback: %w", deleteErr))
+			}
+		}
+	}
+
+	return err
+}
+
+// Reports per backend, primary first, whether filename exists. Backends that can't be checked count as
+// having it so a rollback never deletes an object it didn't create.
+func (c *MirrorClient) existing(ctx context.Context, filename string) []bool {
+	backends := c.backends()
+	existed := make([]bool, len(backends))
+
+	var wg sync.WaitGroup
+	for i, b := range backends {
+		wg.Go(func() {
+			exists, err := FileExists(ctx, b, filename)
+			existed[i] = exists || err != nil
+		})
+	}
+	wg.Wait()
+
+	return existed
+}
+
+func (c *MirrorClient) DeleteFile(filename string) error {
+	return c.DeleteFileContext(context.Background(), filename)
+}
+
+func (c *MirrorClient) DeleteFileContext(ctx context.Context, filename string) error {
+	errs := c.each(func(b Cdn) error {
+		return b.DeleteFileContext(ctx, filename)
+	})
+	return c.writeResult("delete", filename, errs)
+}
+
+// Lists the primary, or the first secondary that works if the primary fails before yielding anything.
+func (c *MirrorClient) ListFiles(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		var errs []error
+		for _, b := range c.backends() {
+			yielded := false
+			var listErr error
+			for obj, err := range b.ListFiles(ctx, prefix) {
+				if err != nil {
+					listErr = err
+					break
+				}
+				yielded = true
+				if !yield(obj, nil) {
+					return
+				}
+			}
+			if listErr == nil {
+				return
+			}
+			if yielded || ctx.Err() != nil {
+				yield(ObjectInfo{}, listErr)
+				return
+			}
+			errs = append(errs, listErr)
+		}
+		yield(ObjectInfo{}, errors.Join(errs...))
+	}
+}
+
+func (c *MirrorClient) GetFile(ctx context.Context, filename string) (io.ReadCloser, ObjectMetadata, error) {
+	var meta ObjectMetadata
+	body, err := mirrorRead(ctx, c.backends(), func(b Cdn) (io.ReadCloser, error) {
+		var err error
+		var body io.ReadCloser
+		body, meta, err = b.GetFile(ctx, filename)
+		return body, err
+	})
+	return body, meta, err
+}
+
+func (c *MirrorClient) GetFileRange(ctx context.Context, filename string, offset int64, length int64) (io.ReadCloser, ObjectMetadata, error) {
+	var meta ObjectMetadata
+	body, err := mirrorRead(ctx, c.backends(), func(b Cdn) (io.ReadCloser, error) {
+		var err error
+		var body io.ReadCloser
+		body, meta, err = b.GetFileRange(ctx, filename, offset, length)
+		return body, err
+	})
+	return body, meta, err
+}
+
+func (c *MirrorClient) HeadFile(ctx context.Context, filename string) (ObjectMetadata, error) {
+	return mirrorRead(ctx, c.backends(), func(b Cdn) (ObjectMetadata, error) {
+		return b.HeadFile(ctx, filename)
+	})
+}
+
+// Tries the backends in order until one succeeds, so files not yet backfilled are read from the
+// old provider. If every backend fails the primary's error is returned when they all report
+// ErrNotFound, otherwise all of them joined.
+func mirrorRead[T any](ctx context.Context, backends []Cdn, fn func(Cdn) (T, error)) (T, error) {
+	var zero T
+	var errs []error
+	allNotFound := true
+	for _, b := range backends {
+		v, err := fn(b)
+		if err == nil {
+			return v, nil
+		}
+		if ctx.Err() != nil {
+			return zero, err
+		}
+		errs = append(errs, err)
+		allNotFound = allNotFound && errors.Is(err, ErrNotFound)
+	}
+	if allNotFound {
+		return zero, errs[0]
+	}
+	return zero, errors.Join(errs...)
+}
+
+type BackfillConfig struct {
+	Prefix      string // only filenames under this prefix are copied
+	Concurrency int    // copies in flight, default 4
+	DryRun      bool   // report what would be copied without copying
+	// Copy objects that exist in the destination with the same size, by default they are skipped
+	Overwrite bool
+}
+
+type BackfillReport struct {
+	Copied  []string // for a dry run the ones that would have been copied
+	Skipped int      // already in the destination with the same size
+	Failed  map[string]error
+}
+
+type optionsReader interface {
+	FileOptions(ctx context.Context, filename string) (UploadOptions, error)
+}
+
+type streamPutter interface {
+	PutStreamWithOptions(ctx context.Context, filename string, r io.Reader, contentType string, opts UploadOptions, progress ProgressFunc) error
+}
+
+// Copies objects from one backend to another under the same filenames, ex: from the old provider to
+// the new one before switching a MirrorClient's primary. Objects already in the destination with
+// the same size are skipped so an interrupted backfill can be rerun.
+// Between S3 clients objects are streamed and keep their cache control, content disposition,
+// metadata, tags and ACL, other destinations only get the content type.
+func Backfill(ctx context.Context, from Cdn, to Cdn, config BackfillConfig) (BackfillReport, error) {
+	report := BackfillReport{Failed: make(map[string]error)}
+
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaultConcurrency
+	}
+
+	existing := make(map[string]int64)
+	if !config.Overwrite {
+		for obj, err := range to.ListFiles(ctx, config.Prefix) {
+			if err != nil {
+				return report, fmt.Errorf("list destination: %w", err)
+			}
+			existing[obj.Filename] = obj.Size
+		}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, config.Concurrency)
+	)
+
+	backfill := func(filename string) {
+		defer wg.Done()
+		defer func() { <-sem }()
+
+		err := backfillFile(ctx, from, to, filename)
+
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			report.Failed[filename] = err
+			return
+		}
+		report.Copied = append(report.Copied, filename)
+	}
+
+	var listErr error
+	for obj, err := range from.ListFiles(ctx, config.Prefix) {
+		if err != nil {
+			listErr = fmt.Errorf("list source: %w", err)
+			break
+		}
+
+		if size, ok := existing[obj.Filename]; ok && size == obj.Size {
+			report.Skipped++
+			continue
+		}
+
+		if config.DryRun {
+			report.Copied = append(report.Copied, obj.Filename)
+			continue
+		}
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go backfill(obj.Filename)
+	}
+	wg.Wait()
+
+	return report, listErr
+}
+
+// Copies one object, streaming it when the destination can store a stream under a given filename.
+func backfillFile(ctx context.Context, from Cdn, to Cdn, filename string) error {
+	body, meta, err := from.GetFile(ctx, filename)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	opts := UploadOptions{
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		Metadata:           meta.Metadata,
+	}
+	if r, ok := from.(optionsReader); ok {
+		if opts, err = r.FileOptions(ctx, filename); err != nil {
+			return fmt.Errorf("read options: %w", err)
+		}
+	}
+
+	if p, ok := to.(streamPutter); ok {
+		return p.PutStreamWithOptions(ctx, filename, body, meta.ContentType, opts, nil)
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	return to.PutFileContext(ctx, filename, data, meta.ContentType)
+}
@@ -0,0 +1,168 @@
+package cdn_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+)
+
+func newMirrorClient(t *testing.T, config cdn.MirrorConfig) *cdn.MirrorClient {
+	t.Helper()
+	c, err := cdn.CreateMirrorClient(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestMirrorWriteAllRollback(t *testing.T) {
+	primary, secondary := newMemoryClient(t), newMemoryClient(t)
+	c := newMirrorClient(t, cdn.MirrorConfig{Primary: primary, Secondaries: []cdn.Cdn{secondary}})
+
+	if err := primary.PutFile("old.txt", []byte("v1"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	secondary.FailAll(cdn.MemoryOpUpload, nil)
+
+	for _, filename := range []string{"old.txt", "new.txt"} {
+		if err := c.PutFile(filename, []byte("v2"), "text/plain"); !errors.Is(err, cdn.ErrInjectedFault) {
+			t.Fatalf("put %s = %v, want ErrInjectedFault", filename, err)
+		}
+	}
+
+	// the object the put created is rolled back, the one that was already there is kept
+	if _, ok := primary.Object("new.txt"); ok {
+		t.Fatal("new.txt left on the primary after the rollback")
+	}
+	if _, ok := primary.Object("old.txt"); !ok {
+		t.Fatal("old.txt deleted by the rollback")
+	}
+
+	// where existence can't be checked nothing is deleted
+	primary.FailAll(cdn.MemoryOpRead, nil)
+	if err := c.PutFile("unknown.txt", []byte("v2"), "text/plain"); err == nil {
+		t.Fatal("put succeeded with a failing secondary")
+	}
+	if _, ok := primary.Object("unknown.txt"); !ok {
+		t.Fatal("unknown.txt deleted by the rollback")
+	}
+	if n := len(primary.CallsOf(cdn.MemoryOpDelete)); n != 1 {
+		t.Fatalf("%d rollback deletes, want 1", n)
+	}
+}
+
+func TestMirrorWritePrimary(t *testing.T) {
+	primary, secondary := newMemoryClient(t), newMemoryClient(t)
+	var failed []string
+	c := newMirrorClient(t, cdn.MirrorConfig{
+		Primary:     primary,
+		Secondaries: []cdn.Cdn{secondary},
+		WritePolicy: cdn.MirrorWritePrimary,
+		OnSecondaryError: func(index int, op string, filename string, err error) {
+			failed = append(failed, op+" "+filename)
+		},
+	})
+	secondary.FailAll(cdn.MemoryOpUpload, nil)
+
+	filename, err := c.UploadFile([]byte("x"), "txt", "text/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := primary.Object(filename); !ok {
+		t.Fatal("upload not kept on the primary")
+	}
+	if !slices.Equal(failed, []string{"put " + filename}) {
+		t.Fatalf("secondary errors %v", failed)
+	}
+}
+
+func TestMirrorReadFallback(t *testing.T) {
+	primary, secondary := newMemoryClient(t), newMemoryClient(t)
+	c := newMirrorClient(t, cdn.MirrorConfig{Primary: primary, Secondaries: []cdn.Cdn{secondary}})
+	ctx := context.Background()
+
+	// not backfilled yet, only the old provider has it
+	if err := secondary.PutFile("a.txt", []byte("old"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAll(t, c, "a.txt"); err != nil || string(got) != "old" {
+		t.Fatalf("read %q, %v", got, err)
+	}
+
+	if err := primary.PutFile("a.txt", []byte("new"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := readAll(t, c, "a.txt"); err != nil || string(got) != "new" {
+		t.Fatalf("read %q, %v, want the primary's copy", got, err)
+	}
+
+	primary.FailAll(cdn.MemoryOpRead, nil)
+	if meta, err := c.HeadFile(ctx, "a.txt"); err != nil || meta.Size != 3 {
+		t.Fatalf("HeadFile with a failing primary = %+v, %v", meta, err)
+	}
+
+	// a failure other than not found is reported with the others
+	if _, err := c.HeadFile(ctx, "missing.txt"); !errors.Is(err, cdn.ErrInjectedFault) || !errors.Is(err, cdn.ErrNotFound) {
+		t.Fatalf("HeadFile of a missing file = %v", err)
+	}
+	primary.Reset()
+	if _, err := c.HeadFile(ctx, "missing.txt"); !errors.Is(err, cdn.ErrNotFound) {
+		t.Fatalf("HeadFile of a missing file = %v, want ErrNotFound", err)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	from, to := newMemoryClient(t), newMemoryClient(t)
+	ctx := context.Background()
+	for filename, data := range map[string]string{"a.txt": "aaa", "b.txt": "bbb", "c.txt": "ccc", "logs/d.txt": "ddd"} {
+		if err := from.PutFile(filename, []byte(data), "text/plain"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// same size is taken as already copied, a different size as a partial or stale copy
+	if err := to.PutFile("a.txt", []byte("AAA"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.PutFile("b.txt", []byte("b"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := cdn.Backfill(ctx, from, to, cdn.BackfillConfig{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(report.Copied)
+	if !slices.Equal(report.Copied, []string{"b.txt", "c.txt", "logs/d.txt"}) || report.Skipped != 1 {
+		t.Fatalf("dry run %+v", report)
+	}
+	if n := len(to.CallsOf(cdn.MemoryOpUpload)); n != 2 {
+		t.Fatalf("dry run uploaded %d files", n-2)
+	}
+
+	report, err = cdn.Backfill(ctx, from, to, cdn.BackfillConfig{Prefix: "logs/"})
+	if err != nil || !slices.Equal(report.Copied, []string{"logs/d.txt"}) {
+		t.Fatalf("backfill of logs/ = %+v, %v", report, err)
+	}
+
+	report, err = cdn.Backfill(ctx, from, to, cdn.BackfillConfig{})
+	if err != nil || len(report.Copied) != 2 || report.Skipped != 2 || len(report.Failed) != 0 {
+		t.Fatalf("backfill = %+v, %v", report, err)
+	}
+	if obj, _ := to.Object("a.txt"); string(obj.Data) != "AAA" {
+		t.Fatalf("skipped a.txt was overwritten with %q", obj.Data)
+	}
+	if obj, _ := to.Object("b.txt"); string(obj.Data) != "bbb" {
+		t.Fatalf("b.txt is %q, want the source's copy", obj.Data)
+	}
+
+	report, err = cdn.Backfill(ctx, from, to, cdn.BackfillConfig{Overwrite: true})
+	if err != nil || len(report.Copied) != 4 || report.Skipped != 0 {
+		t.Fatalf("backfill with Overwrite = %+v, %v", report, err)
+	}
+	if obj, _ := to.Object("a.txt"); string(obj.Data) != "aaa" {
+		t.Fatalf("a.txt is %q after Overwrite", obj.Data)
+	}
+}
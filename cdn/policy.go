@@ -0,0 +1,281 @@
+package cdn
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/c-malecki/go-utils/img/exif"
+)
+
+var (
+	ErrEmptyFile         = errors.New("file is empty")
+	ErrFileTooLarge      = errors.New("file is too large")
+	ErrTypeNotAllowed    = errors.New("file type is not allowed")
+	ErrExtensionMismatch = errors.New("file extension does not match its content")
+	ErrActiveContent     = errors.New("file contains active content")
+	ErrInvalidImage      = errors.New("image is invalid")
+)
+
+// Returned for uploads rejected by an UploadPolicy. Use errors.Is with the Err* sentinels
+// or StatusCode to map it to a response.
+type PolicyError struct {
+	Err    error // one of the Err* sentinels above
+	Detail string
+}
+
+func (e *PolicyError) Error() string {
+	return e.Err.Error() + ": " + e.Detail
+}
+
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// HTTP status matching the violation: 413 for size, 415 for type or extension, 422 for content.
+func (e *PolicyError) StatusCode() int {
+	switch e.Err {
+	case ErrFileTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrTypeNotAllowed, ErrExtensionMismatch:
+		return http.StatusUnsupportedMediaType
+	case ErrActiveContent, ErrInvalidImage:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusBadRequest
+	}
+}
+
+type UploadPolicy struct {
+	MaxSize int64 // bytes, 0 for no limit
+	// Media types checked against the sniffed content, not the content type passed by the caller.
+	// A trailing wildcard matches a whole family, ex: "image/*". Empty allows everything.
+	AllowedTypes []string
+	// Reject uploads whose extension maps to a different media type than the sniffed content
+	RequireMatchingExtension bool
+	// Reject SVGs with scripts, event handlers or external references and binary files with embedded
+	// HTML, script, PDF or ZIP payloads
+	RejectActiveContent bool
+	// Remove EXIF (including GPS coordinates), XMP, IPTC and comments from JPEG, PNG and WebP uploads,
+	// rotating images with an EXIF orientation upright first. Images without metadata are stored as is.
+	// PresignUpload can't apply it, those uploads never pass through the client.
+	StripMetadata bool
+}
+
+// Image uploads that are safe to serve from a public bucket
+var ImageUploadPolicy = UploadPolicy{
+	MaxSize:                  10 * 1024 * 1024,
+	AllowedTypes:             []string{"image/png", "image/jpeg", "image/gif", "image/webp"},
+	RequireMatchingExtension: true,
+	RejectActiveContent:      true,
+}
+
+// Types http.DetectContentType can't tell apart or that mime.TypeByExtension may not know
+var extensionTypes = map[string][]string{
+	".jpg":  {"image/jpeg"},
+	".jpeg": {"image/jpeg"},
+	".png":  {"image/png"},
+	".gif":  {"image/gif"},
+	".webp": {"image/webp"},
+	".svg":  {"image/svg+xml"},
+	".pdf":  {"application/pdf"},
+	".csv":  {"text/plain", "text/csv"},
+	".txt":  {"text/plain"},
+	".json": {"text/plain", "application/json"},
+	".xml":  {"text/xml", "application/xml"},
+	".html": {"text/html"},
+	".zip":  {"application/zip"},
+	".mp4":  {"video/mp4"},
+	".webm": {"video/webm"},
+	".mp3":  {"audio/mpeg"},
+	".wav":  {"audio/wave"},
+	".bmp":  {"image/bmp"},
+	".avif": {"image/avif"},
+}
+
+var (
+	svgRe           = regexp.MustCompile(`(?i)<svg[\s>]`)
+	svgActiveRe     = regexp.MustCompile(`(?i)<script|<foreignobject|<!entity|\son[a-z]+\s*=|javascript:|(?:xlink:)?href\s*=\s*["']\s*(?:https?:|//|data:)`)
+	embeddedMarkups = [][]byte{[]byte("<script"), []byte("<html"), []byte("<?php"), []byte("<iframe"), []byte("<body")}
+)
+
+// Sniffs the media type of data, recognizing SVG which http.DetectContentType reports as text or XML.
+func DetectContentType(data []byte) string {
+	contentType := http.DetectContentType(data)
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if (mediaType == "text/xml" || mediaType == "text/plain") && svgRe.Match(data) {
+		return "image/svg+xml"
+	}
+	return contentType
+}
+
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(contentType)
+	}
+	return mt
+}
+
+func typeAllowed(allowed []string, mt string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		a = strings.ToLower(a)
+		if family, ok := strings.CutSuffix(a, "/*"); ok && strings.HasPrefix(mt, family+"/") {
+			return true
+		}
+		if a == mt {
+			return true
+		}
+	}
+	return false
+}
+
+func extensionMatches(ext string, mt string) bool {
+	ext = "." + strings.ToLower(strings.TrimPrefix(ext, "."))
+	if types, ok := extensionTypes[ext]; ok {
+		return slices.Contains(types, mt)
+	}
+	byExt := mime.TypeByExtension(ext)
+	return byExt != "" && mediaType(byExt) == mt
+}
+
+func hasActiveContent(data []byte, mt string) (string, bool) {
+	if mt == "image/svg+xml" {
+		if m := svgActiveRe.Find(data); m != nil {
+			return fmt.Sprintf("svg contains \"%s\"", m), true
+		}
+		return "", false
+	}
+
+	// text types are expected to contain markup, anything else that does is a polyglot
+	if strings.HasPrefix(mt, "text/") {
+		return "", false
+	}
+
+	lower := bytes.ToLower(data)
+	for _, marker := range embeddedMarkups {
+		if bytes.Contains(lower, marker) {
+			return fmt.Sprintf("%s contains embedded \"%s\"", mt, marker), true
+		}
+	}
+	if mt != "application/pdf" && bytes.Contains(data[:min(len(data), 1024)], []byte("%PDF-")) {
+		return fmt.Sprintf("%s contains an embedded pdf", mt), true
+	}
+	// the zip end of central directory record sits within the last 64 KiB of the file
+	if mt != "application/zip" && bytes.Contains(data[max(0, len(data)-65557):], []byte("PK\x05\x06")) {
+		return fmt.Sprintf("%s contains an embedded zip archive", mt), true
+	}
+
+	return "", false
+}
+
+// Checks data uploaded with extension ext against the policy and returns its sniffed content type.
+// Violations are returned as *PolicyError.
+func (p UploadPolicy) Validate(data []byte, ext string) (string, error) {
+	if len(data) == 0 {
+		return "", &PolicyError{Err: ErrEmptyFile, Detail: "0 bytes"}
+	}
+	if p.MaxSize > 0 && int64(len(data)) > p.MaxSize {
+		return "", &PolicyError{Err: ErrFileTooLarge, Detail: fmt.Sprintf("%d bytes exceeds the limit of %d", len(data), p.MaxSize)}
+	}
+
+	contentType := DetectContentType(data)
+	mt := mediaType(contentType)
+
+	if !typeAllowed(p.AllowedTypes, mt) {
+		return "", &PolicyError{Err: ErrTypeNotAllowed, Detail: mt}
+	}
+	if p.RequireMatchingExtension && !extensionMatches(ext, mt) {
+		return "", &PolicyError{Err: ErrExtensionMismatch, Detail: fmt.Sprintf("extension \"%s\" for %s content", ext, mt)}
+	}
+	if p.RejectActiveContent {
+		if detail, ok := hasActiveContent(data, mt); ok {
+			return "", &PolicyError{Err: ErrActiveContent, Detail: detail}
+		}
+	}
+
+	return contentType, nil
+}
+
+// types that can run scripts when served, which presigned uploads can't be scanned for
+var activeTypes = []string{"image/svg+xml", "text/html", "application/xhtml+xml", "text/xml", "application/xml"}
+
+// Checks the declared type and size of a presigned upload, whose content never passes through the
+// client. A ContentType is required, and a ContentLength when MaxSize is set, so S3 enforces both.
+func (p UploadPolicy) validatePresign(ext string, contentType string, size int64) error {
+	if p.MaxSize > 0 && (size <= 0 || size > p.MaxSize) {
+		return &PolicyError{Err: ErrFileTooLarge, Detail: fmt.Sprintf("presigned uploads need a ContentLength of at most %d, got %d", p.MaxSize, size)}
+	}
+	if len(contentType) == 0 {
+		return &PolicyError{Err: ErrTypeNotAllowed, Detail: "presigned uploads need a ContentType"}
+	}
+
+	mt := mediaType(contentType)
+	if !typeAllowed(p.AllowedTypes, mt) {
+		return &PolicyError{Err: ErrTypeNotAllowed, Detail: mt}
+	}
+	if !extensionMatches(ext, mt) {
+		return &PolicyError{Err: ErrExtensionMismatch, Detail: fmt.Sprintf("extension \"%s\" for %s content", ext, mt)}
+	}
+	if p.RejectActiveContent && slices.Contains(activeTypes, mt) {
+		return &PolicyError{Err: ErrActiveContent, Detail: fmt.Sprintf("%s can't be scanned in a presigned upload", mt)}
+	}
+
+	return nil
+}
+
+// Like Validate for a filename, using its extension.
+func (p UploadPolicy) ValidateFile(data []byte, filename string) (string, error) {
+	return p.Validate(data, strings.TrimPrefix(path.Ext(filename), "."))
+}
+
+var metadataTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+// Whether the policy strips metadata from content of media type mt.
+func (p UploadPolicy) stripsMetadata(mt string) bool {
+	return p.StripMetadata && slices.Contains(metadataTypes, mt)
+}
+
+// Applies StripMetadata to data of media type mt, returning data as is when there is nothing to strip.
+func (p UploadPolicy) strip(data []byte, mt string) ([]byte, error) {
+	if !p.stripsMetadata(mt) {
+		return data, nil
+	}
+	// rotated JPEG and PNG images are decoded to be re-encoded upright
+	if mt != "image/webp" && exif.Orientation(data) != 1 {
+		if err := checkImageSize(data); err != nil {
+			return nil, err
+		}
+	}
+	stripped, err := exif.Strip(data)
+	if err != nil {
+		return nil, &PolicyError{Err: ErrInvalidImage, Detail: err.Error()}
+	}
+	return stripped, nil
+}
+
+// Fails reads once more than max bytes have come through, for streamed uploads whose size isn't known upfront.
+type maxSizeReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (r *maxSizeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	if r.n > r.max {
+		return n, &PolicyError{Err: ErrFileTooLarge, Detail: fmt.Sprintf("more than %d bytes", r.max)}
+	}
+	return n, err
+}
@@ -0,0 +1,88 @@
+package cdn_test
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+)
+
+var (
+	pngMagic  = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	jpegMagic = []byte("\xff\xd8\xff\xe0\x00\x10JFIF\x00")
+	gifMagic  = []byte("GIF89a\x01\x00\x01\x00")
+)
+
+func TestValidate(t *testing.T) {
+	policy := cdn.UploadPolicy{
+		MaxSize:                  1024,
+		AllowedTypes:             []string{"image/*", "application/pdf", "text/plain"},
+		RequireMatchingExtension: true,
+		RejectActiveContent:      true,
+	}
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		ext    string
+		err    error
+		status int
+	}{
+		{"png", pngMagic, "png", nil, 0},
+		{"jpeg as jpg", jpegMagic, "jpg", nil, 0},
+		{"jpeg as jpeg", jpegMagic, "JPEG", nil, 0},
+		{"pdf", []byte("%PDF-1.7\n"), "pdf", nil, 0},
+		{"csv", []byte("a,b\n1,2\n"), "csv", nil, 0},
+		{"clean svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><path d="M0 0"/></svg>`), "svg", nil, 0},
+		{"svg with a local reference", []byte(`<svg><use href="#icon"/></svg>`), "svg", nil, 0},
+
+		{"empty", nil, "png", cdn.ErrEmptyFile, http.StatusBadRequest},
+		{"too large", append(bytes.Repeat(pngMagic, 100), 0), "png", cdn.ErrFileTooLarge, http.StatusRequestEntityTooLarge},
+		{"html", []byte("<!DOCTYPE html><html></html>"), "html", cdn.ErrTypeNotAllowed, http.StatusUnsupportedMediaType},
+		{"zip", []byte("PK\x03\x04\x14\x00"), "zip", cdn.ErrTypeNotAllowed, http.StatusUnsupportedMediaType},
+		{"png as jpg", pngMagic, "jpg", cdn.ErrExtensionMismatch, http.StatusUnsupportedMediaType},
+		{"png as html", pngMagic, "html", cdn.ErrExtensionMismatch, http.StatusUnsupportedMediaType},
+		{"png without extension", pngMagic, "", cdn.ErrExtensionMismatch, http.StatusUnsupportedMediaType},
+		{"svg with script", []byte(`<svg><script>alert(1)</script></svg>`), "svg", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"svg with event handler", []byte(`<svg onload="alert(1)"></svg>`), "svg", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"svg with foreignObject", []byte(`<svg><foreignObject><p/></foreignObject></svg>`), "svg", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"svg with javascript url", []byte(`<svg><a href="javascript:alert(1)"/></svg>`), "svg", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"svg with external reference", []byte(`<svg><image xlink:href="https://evil.example/x.png"/></svg>`), "svg", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"svg with entity", []byte(`<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY x "y">]><svg></svg>`), "svg", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"png with html", append(bytes.Clone(pngMagic), "<HTML><body>"...), "png", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"gif with script", append(bytes.Clone(gifMagic), "<script>alert(1)</script>"...), "gif", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"jpeg with php", append(bytes.Clone(jpegMagic), "<?php system($_GET['c']); ?>"...), "jpg", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"gif with pdf", append(bytes.Clone(gifMagic), "%PDF-1.4"...), "gif", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+		{"png with zip", append(bytes.Clone(pngMagic), "PK\x05\x06\x00\x00"...), "png", cdn.ErrActiveContent, http.StatusUnprocessableEntity},
+	} {
+		_, err := policy.Validate(tc.data, tc.ext)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s: err = %v, want %v", tc.name, err, tc.err)
+		}
+		if tc.err == nil {
+			continue
+		}
+		var policyErr *cdn.PolicyError
+		if !errors.As(err, &policyErr) {
+			t.Fatalf("%s: %T is not a *PolicyError", tc.name, err)
+		}
+		if status := policyErr.StatusCode(); status != tc.status {
+			t.Fatalf("%s: status %d, want %d", tc.name, status, tc.status)
+		}
+	}
+}
+
+func TestValidateWithoutActiveContentCheck(t *testing.T) {
+	policy := cdn.UploadPolicy{AllowedTypes: []string{"image/*"}}
+
+	polyglot := append(bytes.Clone(pngMagic), "<script>alert(1)</script>"...)
+	contentType, err := policy.Validate(polyglot, "html")
+	if err != nil {
+		t.Fatalf("policy without checks rejected the upload: %v", err)
+	}
+	if contentType != "image/png" {
+		t.Fatalf("sniffed %q, want image/png", contentType)
+	}
+}
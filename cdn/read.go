@@ -0,0 +1,86 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	ErrNotFound     = errors.New("file not found")
+	ErrInvalidRange = errors.New("range is not satisfiable")
+)
+
+type ObjectMetadata struct {
+	ObjectInfo         // Size is the size of the whole object, also for ranged reads
+	ContentType        string
+	CacheControl       string            // S3 only
+	ContentDisposition string            // S3 only
+	Metadata           map[string]string // x-amz-meta-* headers, keys are lower case. S3 only
+	TagCount           int               // S3 only, tags are read with GetFileTags
+}
+
+// Reports whether filename exists, errors other than ErrNotFound are returned as is.
+func FileExists(ctx context.Context, c Cdn, filename string) (bool, error) {
+	_, err := c.HeadFile(ctx, filename)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Reads a whole file into memory, for files known to be small.
+func ReadFile(ctx context.Context, c Cdn, filename string) ([]byte, ObjectMetadata, error) {
+	body, meta, err := c.GetFile(ctx, filename)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	return data, meta, nil
+}
+
+func notFound(key string) error {
+	return fmt.Errorf("object \"%s\": %w", key, ErrNotFound)
+}
+
+// Checks a range for GetFileRange, length -1 reads to the end of the file.
+func validateRange(offset int64, length int64) error {
+	if offset < 0 {
+		return fmt.Errorf("offset \"%d\" is invalid: must not be negative", offset)
+	}
+	if length == 0 || length < -1 {
+		return fmt.Errorf("length \"%d\" is invalid: must be positive or -1 to read to the end", length)
+	}
+	return nil
+}
+
+// Clamps a validated range to a file of size bytes and returns the number of bytes to read.
+func rangeLength(offset int64, length int64, size int64) (int64, error) {
+	if offset >= size {
+		return 0, fmt.Errorf("offset %d of a %d byte file: %w", offset, size, ErrInvalidRange)
+	}
+	if length == -1 || offset+length > size {
+		return size - offset, nil
+	}
+	return length, nil
+}
+
+// Body that releases something else when closed, ex: the timeout context of the request it came from.
+type closeFunc struct {
+	io.Reader
+	close func() error
+}
+
+func (c closeFunc) Close() error {
+	return c.close()
+}
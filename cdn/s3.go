@@ -2,46 +2,125 @@ package cdn
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"iter"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/c-malecki/go-utils/gen"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/c-malecki/go-utils/img/avatar"
 )
 
-/*
-	TODO:
-	"github.com/aws/aws-sdk-go/aws/session" is deprecated: Deprecated: aws-sdk-go is deprecated. Use aws-sdk-go-v2.
-	See https://aws.amazon.com/blogs/developer/announcing-end-of-support-for-aws-sdk-for-go-v1-on-july-31-2025/.
-*/
-
 type S3ClientConfig struct {
-	PublicURL    string // ex: https://cdn.example.com/ or https://cdn.staging.example.com/
+	PublicURL string // ex: https://cdn.example.com/ or https://cdn.staging.example.com/
+	// Objects are stored under PublicPrefix/filename. Clients before the aws-sdk-go-v2 port stored
+	// them under filename/filename, which GetFile, HeadFile, URL, ListFiles and GC don't find.
+	// Deletes remove both keys, to migrate copy every old key to the new layout and delete it, ex:
+	// aws s3 mv s3://bucket/a.png/a.png s3://bucket/staging/a.png --acl public-read
 	PublicPrefix string // ex: staging ->  https://cdn.staging.example.com/staging/
-	S3Endpoint   string // ex: "https://nyc3.digitaloceanspaces.com"
-	S3Region     string // ex: "nyc3"
+	S3Endpoint   string // ex: "https://nyc3.digitaloceanspaces.com", "http://localhost:9000" or "https://<account>.r2.cloudflarestorage.com"
+	S3Region     string // ex: "nyc3", "us-east-1" or "auto"
 	S3Key        string
 	S3Secret     string
 	S3Bucket     string
+	S3PathStyle  bool // ex: true for MinIO -> http://localhost:9000/bucket/key instead of http://bucket.localhost:9000/key
+	// UploadStream switches to multipart upload for bodies larger than this, default 16 MiB, minimum 5 MiB
+	MultipartPartSize int64
+	// Parts uploaded at once by UploadStream, default 4
+	MultipartConcurrency int
+	// Canned ACL applied to uploads, default "public-read". With a non public ACL such as "private"
+	// SetImage and URL return presigned URLs instead of PublicURL + filename
+	ACL string
+	// How long URLs built for non public objects stay valid, default 1 hour, maximum 7 days
+	SignedURLExpiry time.Duration
+	// Checked before every upload when set, ex: &cdn.ImageUploadPolicy
+	UploadPolicy *UploadPolicy
+	// Attempts per request including the first, transient 5xx, 429 and throttling errors are retried
+	// with jittered exponential backoff. Default 3, 1 disables retries
+	RetryMaxAttempts int
+	// Upper bound of the delay between attempts, default 20 seconds
+	RetryMaxBackoff time.Duration
+	// Deadline applied to calls whose context has none, covering all attempts. 0 for no deadline.
+	// Not applied to UploadStream and GetFile whose duration depends on the body size
+	Timeout time.Duration
+	// Names new uploads, default UniqueKeys. ex: DateKeys(ULIDKeys) or TenantKeys(UUIDv7Keys)
+	KeyStrategy KeyStrategy
+	// Server side encryption of new objects: "AES256" (SSE-S3), "aws:kms" or "aws:kms:dsse" (SSE-KMS).
+	// Empty leaves it to the bucket's default encryption
+	ServerSideEncryption string
+	// KMS key for "aws:kms", ex: arn:aws:kms:us-east-1:123456789012:key/..., empty for the AWS managed key
+	SSEKMSKeyID string
+	// 32 byte key for SSE-C, S3 encrypts with it without storing it so it is sent with every request.
+	// Objects can't be served through PublicURL or presigned URLs, read them with GetFile
+	SSECustomerKey []byte
+}
+
+const (
+	defaultRetryMaxAttempts = 3
+	defaultRetryMaxBackoff  = 20 * time.Second
+)
+
+// Per upload overrides of S3ClientConfig and object metadata
+type UploadOptions struct {
+	ACL                string            // ex: "private" for a contract stored in an otherwise public bucket
+	CacheControl       string            // ex: "public, max-age=31536000, immutable"
+	ContentDisposition string            // ex: AttachmentDisposition("Q3 report.csv") to download under the original filename
+	Metadata           map[string]string // stored as x-amz-meta-* headers, ex: {"uploader-id": "42"}
+	Tags               map[string]string // object tags, at most 10
 }
 
 type S3Client struct {
-	s3     *s3.S3
-	bucket string
-	url    string
-	prefix string
+	s3          *s3.Client
+	presign     *s3.PresignClient
+	bucket      string
+	url         string
+	prefix      string
+	partSize    int64
+	concurrency int
+	acl         types.ObjectCannedACL
+	urlExpiry   time.Duration
+	policy      *UploadPolicy
+	timeout     time.Duration
+	keys        KeyStrategy
+	sse         serverSideEncryption
 }
 
 type Cdn interface {
-	SetPicture(pictureFilename *string) *string
+	SetImage(imageFile string, name string) string
 	UploadFile(fileData []byte, extension string, contentType string) (string, error)
+	PutFile(filename string, fileData []byte, contentType string) error
 	DeleteFile(objectKey string) error
+	// same as above, honouring cancellation and deadlines of ctx
+	UploadFileContext(ctx context.Context, fileData []byte, extension string, contentType string) (string, error)
+	PutFileContext(ctx context.Context, filename string, fileData []byte, contentType string) error
+	DeleteFileContext(ctx context.Context, objectKey string) error
+	ListFiles(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error]
+	// reads, missing files return an error wrapping ErrNotFound
+	GetFile(ctx context.Context, filename string) (io.ReadCloser, ObjectMetadata, error)
+	GetFileRange(ctx context.Context, filename string, offset int64, length int64) (io.ReadCloser, ObjectMetadata, error)
+	HeadFile(ctx context.Context, filename string) (ObjectMetadata, error)
+}
+
+var _ Cdn = (*S3Client)(nil)
+
+func validateACL(acl string) error {
+	if !slices.Contains(types.ObjectCannedACL("").Values(), types.ObjectCannedACL(acl)) {
+		return fmt.Errorf("acl \"%s\" is invalid: must be a canned ACL such as \"public-read\" or \"private\"", acl)
+	}
+	return nil
+}
+
+func isPublicACL(acl types.ObjectCannedACL) bool {
+	return acl == types.ObjectCannedACLPublicRead || acl == types.ObjectCannedACLPublicReadWrite
 }
 
 func validateS3Key(key string) bool {
@@ -49,84 +128,289 @@ func validateS3Key(key string) bool {
 	return len(key) >= 1 && len(key) <= 1024 && re.MatchString(key)
 }
 
+var filenameRe = regexp.MustCompile(`^[a-zA-Z0-9_.-]+(/[a-zA-Z0-9_.-]+)*$`)
+
+// Filenames passed to PutFile may contain "/" but no empty, "." or ".." segments.
+func validateFilename(filename string) error {
+	if len(filename) == 0 || len(filename) > 1024 || !filenameRe.MatchString(filename) {
+		return fmt.Errorf("filename \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 _ - . /\" only", filename)
+	}
+	for _, seg := range strings.Split(filename, "/") {
+		if seg == "." || seg == ".." {
+			return fmt.Errorf("filename \"%s\" is invalid: must not contain \".\" or \"..\" segments", filename)
+		}
+	}
+	return nil
+}
+
 func CreateS3Client(config S3ClientConfig) (*S3Client, error) {
 	if len(config.PublicPrefix) > 0 && !validateS3Key(config.PublicPrefix) {
 		return nil, fmt.Errorf("public prefix \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 _ -\" only", config.PublicPrefix)
 	}
+	if config.MultipartPartSize != 0 && config.MultipartPartSize < minPartSize {
+		return nil, fmt.Errorf("multipart part size %d is invalid: must be at least %d bytes", config.MultipartPartSize, minPartSize)
+	}
+	if config.MultipartPartSize == 0 {
+		config.MultipartPartSize = defaultPartSize
+	}
+	if config.MultipartConcurrency <= 0 {
+		config.MultipartConcurrency = defaultConcurrency
+	}
+	if config.ACL == "" {
+		config.ACL = string(types.ObjectCannedACLPublicRead)
+	}
+	if err := validateACL(config.ACL); err != nil {
+		return nil, err
+	}
+	if config.SignedURLExpiry == 0 {
+		config.SignedURLExpiry = defaultSignedURLExpiry
+	}
+	if err := validatePresignExpiry(config.SignedURLExpiry); err != nil {
+		return nil, err
+	}
+	if config.RetryMaxAttempts <= 0 {
+		config.RetryMaxAttempts = defaultRetryMaxAttempts
+	}
+	if config.RetryMaxBackoff <= 0 {
+		config.RetryMaxBackoff = defaultRetryMaxBackoff
+	}
 
-	sess, err := session.NewSession(&aws.Config{
-		Credentials:      credentials.NewStaticCredentials(config.S3Key, config.S3Secret, ""),
-		Endpoint:         aws.String(config.S3Endpoint),
-		Region:           aws.String(config.S3Region),
-		S3ForcePathStyle: aws.Bool(false),
-	})
+	sse, err := newServerSideEncryption(config)
 	if err != nil {
 		return nil, err
 	}
 
-	c := s3.New(sess)
+	opts := s3.Options{
+		Region:       config.S3Region,
+		Credentials:  aws.NewCredentialsCache(credentials.NewStaticCredentialsProvider(config.S3Key, config.S3Secret, "")),
+		UsePathStyle: config.S3PathStyle,
+		// S3 compatible providers (Spaces, MinIO, R2) do not all accept the
+		// default trailing checksums added by the v2 SDK
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+		Retryer:                    newRetryer(config.RetryMaxAttempts, config.RetryMaxBackoff),
+	}
+	if len(config.S3Endpoint) > 0 {
+		opts.BaseEndpoint = aws.String(config.S3Endpoint)
+	}
+
+	c := s3.New(opts)
 
 	client := &S3Client{
-		s3:     c,
-		bucket: config.S3Bucket,
-		url:    config.PublicURL,
-		prefix: config.PublicPrefix,
+		s3:          c,
+		presign:     s3.NewPresignClient(c),
+		bucket:      config.S3Bucket,
+		url:         config.PublicURL,
+		prefix:      config.PublicPrefix,
+		partSize:    config.MultipartPartSize,
+		concurrency: config.MultipartConcurrency,
+		acl:         types.ObjectCannedACL(config.ACL),
+		urlExpiry:   config.SignedURLExpiry,
+		policy:      config.UploadPolicy,
+		timeout:     config.Timeout,
+		keys:        config.KeyStrategy,
+		sse:         sse,
 	}
 
 	return client, nil
 }
 
-func (c *S3Client) SetImage(imageFile string, name string) string {
+func newRetryer(maxAttempts int, maxBackoff time.Duration) aws.Retryer {
+	return retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = maxAttempts
+		o.MaxBackoff = maxBackoff
+		o.Backoff = retry.NewExponentialJitterBackoff(maxBackoff)
+		// S3 compatible providers throttle with a plain 429 rather than SlowDown
+		o.Retryables = append(o.Retryables, retry.RetryableHTTPStatusCode{
+			Codes: map[int]struct{}{http.StatusTooManyRequests: {}},
+		})
+	})
+}
+
+func (c *S3Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok || c.timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+// Object key for a filename, the only place PublicPrefix is added.
+func joinPrefix(prefix string, filename string) string {
+	if len(prefix) > 0 {
+		return prefix + "/" + filename
+	}
+	return filename
+}
+
+// Filename for an object key, the inverse of joinPrefix.
+func trimPrefix(prefix string, objectKey string) string {
+	if len(prefix) > 0 {
+		return strings.TrimPrefix(objectKey, prefix+"/")
+	}
+	return objectKey
+}
+
+func publicImage(url string, imageFile string, name string) string {
 	if strings.HasPrefix(imageFile, "data:image/svg+xml") {
 		return imageFile
 	}
 	if len(imageFile) > 0 {
-		return c.url + imageFile
+		return url + imageFile
 	}
 	return avatar.SVGWithInitials(name)
 }
 
-// TODO: configure ACL
-func (c *S3Client) UploadFile(data []byte, ext string, contentType string) (string, error) {
-	filename := gen.GenerateUniqueFilename(ext)
+// Validates data against policy when there is one and fills in a missing content type.
+// Returns the data to store, which has its metadata removed if the policy strips it.
+func checkUpload(policy *UploadPolicy, data []byte, filename string, contentType string) ([]byte, string, error) {
+	if policy != nil {
+		sniffed, err := policy.ValidateFile(data, filename)
+		if err != nil {
+			return nil, "", err
+		}
+		data, err = policy.strip(data, mediaType(sniffed))
+		if err != nil {
+			return nil, "", err
+		}
+		if contentType == "" {
+			contentType = sniffed
+		}
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(data)
+	}
+	return data, contentType, nil
+}
+
+func (c *S3Client) objectKey(filename string) string {
+	return joinPrefix(c.prefix, filename)
+}
 
-	objectKey := filename
+// Key clients before the aws-sdk-go-v2 port stored filename under when PublicPrefix was set,
+// ex: 1760000000-a1b2c3d4e5f6.png/1760000000-a1b2c3d4e5f6.png. Empty without a prefix, the key was the same.
+func (c *S3Client) legacyObjectKey(filename string) string {
 	if len(c.prefix) > 0 {
-		objectKey = objectKey + "/" + filename
+		return filename + "/" + filename
 	}
+	return ""
+}
 
-	if contentType == "" {
-		contentType = http.DetectContentType(data)
+func (c *S3Client) uploadACL(opts UploadOptions) (types.ObjectCannedACL, error) {
+	if opts.ACL == "" {
+		return c.acl, nil
 	}
+	if err := validateACL(opts.ACL); err != nil {
+		return "", err
+	}
+	return types.ObjectCannedACL(opts.ACL), nil
+}
 
-	_, err := c.s3.PutObject(&s3.PutObjectInput{
-		Bucket:      aws.String(c.bucket),
-		Key:         aws.String(objectKey),
-		Body:        bytes.NewReader(data),
-		ACL:         aws.String("public-read"),
-		ContentType: aws.String(contentType),
-	})
+// Returns PublicURL + filename when uploads are public, otherwise a presigned GET URL valid for SignedURLExpiry.
+// Objects uploaded with a different ACL than the configured one should use PresignDownload instead.
+func (c *S3Client) URL(ctx context.Context, filename string) (string, error) {
+	if isPublicACL(c.acl) {
+		return c.url + filename, nil
+	}
+	return c.PresignDownload(ctx, filename, c.urlExpiry)
+}
 
+// Falls back to the initials avatar if a URL for a non public image can't be signed.
+func (c *S3Client) SetImage(imageFile string, name string) string {
+	if isPublicACL(c.acl) || len(imageFile) == 0 || strings.HasPrefix(imageFile, "data:image/svg+xml") {
+		return publicImage(c.url, imageFile, name)
+	}
+	url, err := c.URL(context.Background(), imageFile)
+	if err != nil {
+		return avatar.SVGWithInitials(name)
+	}
+	return url
+}
+
+func (c *S3Client) UploadFile(data []byte, ext string, contentType string) (string, error) {
+	return c.UploadFileContext(context.Background(), data, ext, contentType)
+}
+
+func (c *S3Client) UploadFileContext(ctx context.Context, data []byte, ext string, contentType string) (string, error) {
+	return c.UploadFileWithOptions(ctx, data, ext, contentType, UploadOptions{})
+}
+
+func (c *S3Client) UploadFileWithOptions(ctx context.Context, data []byte, ext string, contentType string, opts UploadOptions) (string, error) {
+	filename, err := newFilename(ctx, c.keys, data, ext)
 	if err != nil {
 		return "", err
 	}
 
+	if err := c.PutFileWithOptions(ctx, filename, data, contentType, opts); err != nil {
+		return "", err
+	}
+
 	return filename, nil
 }
 
-func (c *S3Client) DeleteFile(filename string) error {
-	objectKey := filename
-	if len(c.prefix) > 0 {
-		objectKey = objectKey + "/" + filename
+// Like UploadFile but stores the object under filename instead of generating one.
+// An existing object with the same filename is overwritten.
+func (c *S3Client) PutFile(filename string, data []byte, contentType string) error {
+	return c.PutFileContext(context.Background(), filename, data, contentType)
+}
+
+func (c *S3Client) PutFileContext(ctx context.Context, filename string, data []byte, contentType string) error {
+	return c.PutFileWithOptions(ctx, filename, data, contentType, UploadOptions{})
+}
+
+func (c *S3Client) PutFileWithOptions(ctx context.Context, filename string, data []byte, contentType string, opts UploadOptions) error {
+	if err := validateFilename(filename); err != nil {
+		return err
+	}
+
+	data, contentType, err := checkUpload(c.policy, data, filename, contentType)
+	if err != nil {
+		return err
+	}
+
+	input, err := c.putObjectInput(filename, contentType, opts)
+	if err != nil {
+		return err
+	}
+	input.Body = bytes.NewReader(data)
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err = c.s3.PutObject(ctx, input)
+	if err != nil {
+		return err
 	}
 
-	_, err := c.s3.DeleteObject(&s3.DeleteObjectInput{
+	return nil
+}
+
+func (c *S3Client) DeleteFile(filename string) error {
+	return c.DeleteFileContext(context.Background(), filename)
+}
+
+// Deletes filename. With a PublicPrefix the key older clients stored it under is deleted as well,
+// see legacyObjectKey, so filenames saved before the port can still be deleted.
+func (c *S3Client) DeleteFileContext(ctx context.Context, filename string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(c.bucket),
-		Key:    aws.String(objectKey),
+		Key:    aws.String(c.objectKey(filename)),
 	})
 	if err != nil {
 		return err
 	}
 
+	if legacy := c.legacyObjectKey(filename); len(legacy) > 0 {
+		_, err := c.s3.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket: aws.String(c.bucket),
+			Key:    aws.String(legacy),
+		})
+		if err != nil {
+			return fmt.Errorf("delete legacy key: %w", err)
+		}
+	}
+
 	return nil
 }
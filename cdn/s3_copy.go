@@ -0,0 +1,122 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// Backends that can copy an object without downloading it, ex: S3Client.
+type fileCopier interface {
+	CopyFile(ctx context.Context, src string, dst string) error
+}
+
+const (
+	allUsersURI           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	authenticatedUsersURI = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// Reads an object's ACL as the canned ACL that grants the same access to everyone else: grants to
+// all users map to public-read or public-read-write, grants to authenticated users to
+// authenticated-read and anything else to private.
+// Providers without the object ACL API, ex: R2, and credentials without s3:GetObjectAcl get the
+// configured ACL instead.
+func (c *S3Client) objectACL(ctx context.Context, objectKey string) (types.ObjectCannedACL, error) {
+	out, err := c.s3.GetObjectAcl(ctx, &s3.GetObjectAclInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(objectKey),
+	})
+	if isNotFound(err) {
+		return "", notFound(objectKey)
+	}
+	if aclUnavailable(err) {
+		return c.acl, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	acl := types.ObjectCannedACLPrivate
+	for _, g := range out.Grants {
+		if g.Grantee == nil {
+			continue
+		}
+		switch uri := aws.ToString(g.Grantee.URI); {
+		case uri == allUsersURI && g.Permission == types.PermissionWrite:
+			return types.ObjectCannedACLPublicReadWrite, nil
+		case uri == allUsersURI && g.Permission == types.PermissionRead:
+			acl = types.ObjectCannedACLPublicRead
+		case uri == authenticatedUsersURI && g.Permission == types.PermissionRead && acl == types.ObjectCannedACLPrivate:
+			acl = types.ObjectCannedACLAuthenticatedRead
+		}
+	}
+
+	return acl, nil
+}
+
+// Copies src to dst inside the bucket keeping content type, metadata, tags and ACL, so a private
+// object stays private in a public bucket. Where the ACL can't be read the copy gets the configured ACL. Missing sources return an error wrapping ErrNotFound.
+// Objects over 5 GiB can't be copied with a single CopyObject and fail.
+func (c *S3Client) CopyFile(ctx context.Context, src string, dst string) error {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	srcKey := c.objectKey(src)
+	// CopyObject doesn't carry the source's ACL over
+	acl, err := c.objectACL(ctx, srcKey)
+	if err != nil {
+		return err
+	}
+
+	alg, key, keyMD5 := c.sse.customerHeaders()
+	_, err = c.s3.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:                         aws.String(c.bucket),
+		Key:                            aws.String(c.objectKey(dst)),
+		CopySource:                     aws.String(c.bucket + "/" + url.PathEscape(srcKey)),
+		MetadataDirective:              types.MetadataDirectiveCopy,
+		ACL:                            acl,
+		ServerSideEncryption:           c.sse.mode,
+		SSEKMSKeyId:                    c.sse.kmsKeyId,
+		SSECustomerAlgorithm:           alg,
+		SSECustomerKey:                 key,
+		SSECustomerKeyMD5:              keyMD5,
+		CopySourceSSECustomerAlgorithm: alg,
+		CopySourceSSECustomerKey:       key,
+		CopySourceSSECustomerKeyMD5:    keyMD5,
+	})
+	if isNotFound(err) {
+		return notFound(srcKey)
+	}
+	return err
+}
+
+func aclUnavailable(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "NotImplemented", "AccessDenied":
+		return true
+	default:
+		return false
+	}
+}
+
+// Copies src to dst with CopyFile when the backend has it, otherwise by reading and writing it.
+func copyFile(ctx context.Context, c Cdn, src string, dst string) error {
+	if fc, ok := c.(fileCopier); ok {
+		return fc.CopyFile(ctx, src, dst)
+	}
+
+	data, meta, err := ReadFile(ctx, c, src)
+	if err != nil {
+		return err
+	}
+	return c.PutFileContext(ctx, dst, data, meta.ContentType)
+}
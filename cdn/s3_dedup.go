@@ -0,0 +1,94 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/c-malecki/go-utils/gen"
+)
+
+// Content addressed objects never change so they can be cached forever, by shared caches only
+// when the objects are public
+const (
+	immutableCacheControl        = "public, max-age=31536000, immutable"
+	privateImmutableCacheControl = "private, max-age=31536000, immutable"
+)
+
+func isNotFound(err error) bool {
+	var notFound *types.NotFound
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &notFound) || errors.As(err, &noSuchKey) {
+		return true
+	}
+	// operations without modeled errors, ex: CopyObject, only carry the code
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey"
+}
+
+func (c *S3Client) objectExists(ctx context.Context, objectKey string) (bool, error) {
+	alg, key, keyMD5 := c.sse.customerHeaders()
+	_, err := c.s3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(c.bucket),
+		Key:                  aws.String(objectKey),
+		SSECustomerAlgorithm: alg,
+		SSECustomerKey:       key,
+		SSECustomerKeyMD5:    keyMD5,
+	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Like UploadFile but names the object by the SHA-256 of data, so uploading the same content twice
+// returns the same filename and the second PUT is skipped. Objects are uploaded with an immutable
+// Cache-Control since their content can never change under the same filename, private unless the
+// configured ACL is public. The SHA-256 is of the stored content, after UploadPolicy.StripMetadata.
+// Deleting a content addressed file removes it for every caller that uploaded the same content.
+func (c *S3Client) UploadFileContentAddressed(ctx context.Context, data []byte, ext string, contentType string) (string, error) {
+	// only the extension of the filename is checked, the policy may rewrite data before it is hashed
+	data, contentType, err := checkUpload(c.policy, data, "."+ext, contentType)
+	if err != nil {
+		return "", err
+	}
+
+	filename := gen.GenerateContentFilename(data, ext)
+	objectKey := c.objectKey(filename)
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	exists, err := c.objectExists(ctx, objectKey)
+	if err != nil {
+		return "", err
+	}
+	if exists {
+		return filename, nil
+	}
+
+	cacheControl := privateImmutableCacheControl
+	if isPublicACL(c.acl) {
+		cacheControl = immutableCacheControl
+	}
+
+	input, err := c.putObjectInput(filename, contentType, UploadOptions{CacheControl: cacheControl})
+	if err != nil {
+		return "", err
+	}
+	input.Body = bytes.NewReader(data)
+
+	_, err = c.s3.PutObject(ctx, input)
+	if err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
@@ -0,0 +1,191 @@
+package cdn
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// DeleteObjects accepts at most 1000 keys per request
+const maxDeleteKeys = 1000
+
+type DeleteFailure struct {
+	Filename string
+	Code     string // S3 error code, ex: AccessDenied
+	Message  string
+}
+
+type DeleteResult struct {
+	Deleted []string // filenames, for a dry run the ones that would have been deleted
+	Failed  []DeleteFailure
+}
+
+// Strips the public prefix from an object key, the inverse of objectKey.
+func (c *S3Client) filename(objectKey string) string {
+	return trimPrefix(c.prefix, objectKey)
+}
+
+// Deletes filenames with DeleteObjects in chunks of 1000 keys. With a PublicPrefix the keys older
+// clients stored them under are deleted in the same requests, see legacyObjectKey. Keys S3 refuses
+// to delete are reported in DeleteResult.Failed, the returned error is for requests that failed as
+// a whole, in which case the result holds what was deleted up to that point.
+func (c *S3Client) DeleteFiles(ctx context.Context, filenames []string) (DeleteResult, error) {
+	var result DeleteResult
+
+	keysPerFile := 1
+	if len(c.prefix) > 0 {
+		keysPerFile = 2
+	}
+
+	for chunk := range slices.Chunk(filenames, maxDeleteKeys/keysPerFile) {
+		objects := make([]types.ObjectIdentifier, 0, len(chunk)*keysPerFile)
+		filenameOf := make(map[string]string, len(chunk)*keysPerFile)
+		for _, filename := range chunk {
+			key := c.objectKey(filename)
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(key)})
+			filenameOf[key] = filename
+			if legacy := c.legacyObjectKey(filename); len(legacy) > 0 {
+				objects = append(objects, types.ObjectIdentifier{Key: aws.String(legacy)})
+				filenameOf[legacy] = filename
+			}
+		}
+
+		reqCtx, cancel := c.withTimeout(ctx)
+		out, err := c.s3.DeleteObjects(reqCtx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(c.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: aws.Bool(true)},
+		})
+		cancel()
+		if err != nil {
+			return result, err
+		}
+
+		failed := make(map[string]bool, len(out.Errors))
+		for _, e := range out.Errors {
+			filename, ok := filenameOf[aws.ToString(e.Key)]
+			if !ok {
+				filename = c.filename(aws.ToString(e.Key))
+			}
+			if failed[filename] {
+				continue
+			}
+			failed[filename] = true
+			result.Failed = append(result.Failed, DeleteFailure{
+				Filename: filename,
+				Code:     aws.ToString(e.Code),
+				Message:  aws.ToString(e.Message),
+			})
+		}
+		for _, filename := range chunk {
+			if !failed[filename] {
+				result.Deleted = append(result.Deleted, filename)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Deletes every object whose filename starts with prefix, ex: "users/123/", including those stored
+// under a legacy key. With dryRun nothing is deleted and DeleteResult.Deleted lists what would have been. An empty prefix is rejected so a
+// missing value can't wipe the bucket.
+func (c *S3Client) DeletePrefix(ctx context.Context, prefix string, dryRun bool) (DeleteResult, error) {
+	if len(prefix) == 0 {
+		return DeleteResult{}, fmt.Errorf("prefix is required")
+	}
+
+	var result DeleteResult
+	var batch []string
+
+	flush := func() error {
+		if dryRun {
+			result.Deleted = append(result.Deleted, batch...)
+			batch = batch[:0]
+			return nil
+		}
+		res, err := c.DeleteFiles(ctx, batch)
+		result.Deleted = append(result.Deleted, res.Deleted...)
+		result.Failed = append(result.Failed, res.Failed...)
+		batch = batch[:0]
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for obj, err := range c.ListFiles(ctx, prefix) {
+		if err != nil {
+			return result, err
+		}
+
+		seen[obj.Filename] = true
+		batch = append(batch, obj.Filename)
+		if len(batch) == maxDeleteKeys {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	// files only stored under their legacy key aren't listed with the prefix
+	for filename, err := range c.legacyFilenames(ctx, prefix) {
+		if err != nil {
+			return result, err
+		}
+		if seen[filename] {
+			continue
+		}
+
+		batch = append(batch, filename)
+		if len(batch) == maxDeleteKeys {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// Lists the filenames starting with prefix that are stored under their legacy key, see legacyObjectKey.
+func (c *S3Client) legacyFilenames(ctx context.Context, prefix string) iter.Seq2[string, error] {
+	return func(yield func(string, error) bool) {
+		if len(c.prefix) == 0 {
+			return
+		}
+
+		// legacy keys start with the filename so they are listed from the bucket root
+		paginator := s3.NewListObjectsV2Paginator(c.s3, &s3.ListObjectsV2Input{
+			Bucket: aws.String(c.bucket),
+			Prefix: aws.String(prefix),
+		})
+		for paginator.HasMorePages() {
+			reqCtx, cancel := c.withTimeout(ctx)
+			page, err := paginator.NextPage(reqCtx)
+			cancel()
+			if err != nil {
+				yield("", err)
+				return
+			}
+
+			for _, obj := range page.Contents {
+				key := aws.ToString(obj.Key)
+				n := len(key) / 2
+				if len(key)%2 == 1 && key[n] == '/' && key[:n] == key[n+1:] {
+					if !yield(key[:n], nil) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package cdn
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"mime"
+	"net/url"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const maxObjectTags = 10
+
+var metadataKeyRe = regexp.MustCompile(`^[a-zA-Z0-9-]+$`)
+
+// Content-Disposition that makes browsers download the file under filename instead of displaying it.
+// Non ASCII filenames are encoded as described in RFC 2231.
+func AttachmentDisposition(filename string) string {
+	return mime.FormatMediaType("attachment", map[string]string{"filename": filename})
+}
+
+func encodeTags(tags map[string]string) string {
+	values := make(url.Values, len(tags))
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+func validateUploadOptions(opts UploadOptions) error {
+	for k := range opts.Metadata {
+		if !metadataKeyRe.MatchString(k) {
+			return fmt.Errorf("metadata key \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 -\" only", k)
+		}
+	}
+	if len(opts.Tags) > maxObjectTags {
+		return fmt.Errorf("%d tags is invalid: objects can have at most %d", len(opts.Tags), maxObjectTags)
+	}
+	for k, v := range opts.Tags {
+		if len(k) == 0 || len(k) > 128 || len(v) > 256 {
+			return fmt.Errorf("tag \"%s\" is invalid: keys must be 1-128 and values at most 256 characters", k)
+		}
+	}
+	return nil
+}
+
+// Builds the PutObject request shared by every upload path, the caller sets Body.
+func (c *S3Client) putObjectInput(filename string, contentType string, opts UploadOptions) (*s3.PutObjectInput, error) {
+	acl, err := c.uploadACL(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateUploadOptions(opts); err != nil {
+		return nil, err
+	}
+
+	alg, key, keyMD5 := c.sse.customerHeaders()
+	input := &s3.PutObjectInput{
+		Bucket:               aws.String(c.bucket),
+		Key:                  aws.String(c.objectKey(filename)),
+		ACL:                  acl,
+		ContentType:          aws.String(contentType),
+		ServerSideEncryption: c.sse.mode,
+		SSEKMSKeyId:          c.sse.kmsKeyId,
+		SSECustomerAlgorithm: alg,
+		SSECustomerKey:       key,
+		SSECustomerKeyMD5:    keyMD5,
+	}
+	if len(opts.CacheControl) > 0 {
+		input.CacheControl = aws.String(opts.CacheControl)
+	}
+	if len(opts.ContentDisposition) > 0 {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if len(opts.Metadata) > 0 {
+		input.Metadata = maps.Clone(opts.Metadata)
+	}
+	if len(opts.Tags) > 0 {
+		input.Tagging = aws.String(encodeTags(opts.Tags))
+	}
+
+	return input, nil
+}
+
+// Reads an object's metadata with a HEAD request. Missing objects return an error wrapping ErrNotFound.
+func (c *S3Client) HeadFile(ctx context.Context, filename string) (ObjectMetadata, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	objectKey := c.objectKey(filename)
+	alg, key, keyMD5 := c.sse.customerHeaders()
+	out, err := c.s3.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket:               aws.String(c.bucket),
+		Key:                  aws.String(objectKey),
+		SSECustomerAlgorithm: alg,
+		SSECustomerKey:       key,
+		SSECustomerKeyMD5:    keyMD5,
+	})
+	if isNotFound(err) {
+		return ObjectMetadata{}, notFound(objectKey)
+	}
+	if err != nil {
+		return ObjectMetadata{}, err
+	}
+
+	meta := ObjectMetadata{
+		ObjectInfo: ObjectInfo{
+			Filename:     filename,
+			Size:         aws.ToInt64(out.ContentLength),
+			ETag:         trimETag(aws.ToString(out.ETag)),
+			LastModified: aws.ToTime(out.LastModified),
+		},
+		ContentType:        aws.ToString(out.ContentType),
+		CacheControl:       aws.ToString(out.CacheControl),
+		ContentDisposition: aws.ToString(out.ContentDisposition),
+		Metadata:           out.Metadata,
+		TagCount:           int(aws.ToInt32(out.TagCount)),
+	}
+
+	return meta, nil
+}
+
+// Reads an object's tags, which HEAD does not return.
+func (c *S3Client) GetFileTags(ctx context.Context, filename string) (map[string]string, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	out, err := c.s3.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.objectKey(filename)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(out.TagSet))
+	for _, t := range out.TagSet {
+		tags[aws.ToString(t.Key)] = aws.ToString(t.Value)
+	}
+
+	return tags, nil
+}
+
+// Reads the options an object was stored with, ex: to upload a copy of it elsewhere with PutStreamWithOptions.
+// Where the ACL can't be read it is the configured ACL, as for CopyFile.
+func (c *S3Client) FileOptions(ctx context.Context, filename string) (UploadOptions, error) {
+	meta, err := c.HeadFile(ctx, filename)
+	if err != nil {
+		return UploadOptions{}, err
+	}
+
+	opts := UploadOptions{
+		CacheControl:       meta.CacheControl,
+		ContentDisposition: meta.ContentDisposition,
+		Metadata:           meta.Metadata,
+	}
+	if meta.TagCount > 0 {
+		if opts.Tags, err = c.GetFileTags(ctx, filename); err != nil {
+			return UploadOptions{}, err
+		}
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	acl, err := c.objectACL(ctx, c.objectKey(filename))
+	if err != nil {
+		return UploadOptions{}, err
+	}
+	opts.ACL = string(acl)
+
+	return opts, nil
+}
+
+// Replaces an object's tags.
+func (c *S3Client) SetFileTags(ctx context.Context, filename string, tags map[string]string) error {
+	if err := validateUploadOptions(UploadOptions{Tags: tags}); err != nil {
+		return err
+	}
+
+	tagSet := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	_, err := c.s3.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(c.bucket),
+		Key:     aws.String(c.objectKey(filename)),
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,249 @@
+package cdn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	minPartSize        = 5 * 1024 * 1024
+	defaultPartSize    = 16 * 1024 * 1024
+	defaultConcurrency = 4
+	maxParts           = 10000
+)
+
+// Called with the total number of bytes uploaded so far. Calls are serialized.
+type ProgressFunc func(uploaded int64)
+
+type uploadPart struct {
+	number int32
+	data   []byte
+}
+
+// Uploads the body of r without holding it in memory. Bodies up to MultipartPartSize are sent
+// with a single PutObject, anything larger is sent as a multipart upload with MultipartConcurrency
+// parts in flight. The multipart upload is aborted if any part fails or ctx is cancelled.
+// Images whose metadata the UploadPolicy strips are read into memory whole. progress may be nil.
+func (c *S3Client) UploadStream(ctx context.Context, r io.Reader, ext string, contentType string, progress ProgressFunc) (string, error) {
+	return c.UploadStreamWithOptions(ctx, r, ext, contentType, UploadOptions{}, progress)
+}
+
+func (c *S3Client) UploadStreamWithOptions(ctx context.Context, r io.Reader, ext string, contentType string, opts UploadOptions, progress ProgressFunc) (string, error) {
+	filename, err := newFilename(ctx, c.keys, nil, ext)
+	if err != nil {
+		return "", err
+	}
+
+	if err := c.PutStreamWithOptions(ctx, filename, r, contentType, opts, progress); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// Like UploadStreamWithOptions but stores the body under filename, replacing any existing object.
+func (c *S3Client) PutStreamWithOptions(ctx context.Context, filename string, r io.Reader, contentType string, opts UploadOptions, progress ProgressFunc) error {
+	if err := validateFilename(filename); err != nil {
+		return err
+	}
+	objectKey := c.objectKey(filename)
+
+	if c.policy != nil && c.policy.MaxSize > 0 {
+		r = &maxSizeReader{r: r, max: c.policy.MaxSize}
+	}
+
+	first, err := readPart(r, c.partSize)
+	if err != nil {
+		return err
+	}
+
+	// metadata can't be stripped part by part, images it is stripped from are read whole
+	whole := int64(len(first)) < c.partSize
+	if !whole && c.policy != nil && c.policy.stripsMetadata(mediaType(DetectContentType(first))) {
+		rest, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		first = append(first, rest...)
+		whole = true
+	}
+
+	// only the first part is sniffed and scanned, the size limit is enforced while reading
+	first, contentType, err = checkUpload(c.policy, first, filename, contentType)
+	if err != nil {
+		return err
+	}
+
+	input, err := c.putObjectInput(filename, contentType, opts)
+	if err != nil {
+		return err
+	}
+
+	if whole {
+		input.Body = bytes.NewReader(first)
+		_, err := c.s3.PutObject(ctx, input)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(int64(len(first)))
+		}
+		return nil
+	}
+
+	created, err := c.s3.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:               input.Bucket,
+		Key:                  input.Key,
+		ACL:                  input.ACL,
+		ContentType:          input.ContentType,
+		CacheControl:         input.CacheControl,
+		ContentDisposition:   input.ContentDisposition,
+		Metadata:             input.Metadata,
+		Tagging:              input.Tagging,
+		ServerSideEncryption: input.ServerSideEncryption,
+		SSEKMSKeyId:          input.SSEKMSKeyId,
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+		SSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+	})
+	if err != nil {
+		return err
+	}
+
+	completed, err := c.uploadParts(ctx, objectKey, created.UploadId, first, r, progress)
+	if err != nil {
+		// ctx may already be cancelled, the abort still has to go out
+		_, abortErr := c.s3.AbortMultipartUpload(context.WithoutCancel(ctx), &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(c.bucket),
+			Key:      aws.String(objectKey),
+			UploadId: created.UploadId,
+		})
+		if abortErr != nil {
+			return errors.Join(err, fmt.Errorf("abort multipart upload: %w", abortErr))
+		}
+		return err
+	}
+
+	_, err = c.s3.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:               aws.String(c.bucket),
+		Key:                  aws.String(objectKey),
+		UploadId:             created.UploadId,
+		MultipartUpload:      &types.CompletedMultipartUpload{Parts: completed},
+		SSECustomerAlgorithm: input.SSECustomerAlgorithm,
+		SSECustomerKey:       input.SSECustomerKey,
+		SSECustomerKeyMD5:    input.SSECustomerKeyMD5,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *S3Client) uploadParts(ctx context.Context, objectKey string, uploadId *string, first []byte, r io.Reader, progress ProgressFunc) ([]types.CompletedPart, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		completed []types.CompletedPart
+		uploaded  int64
+	)
+
+	parts := make(chan uploadPart)
+
+	alg, key, keyMD5 := c.sse.customerHeaders()
+
+	for range c.concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for part := range parts {
+				out, err := c.s3.UploadPart(ctx, &s3.UploadPartInput{
+					Bucket:               aws.String(c.bucket),
+					Key:                  aws.String(objectKey),
+					UploadId:             uploadId,
+					PartNumber:           aws.Int32(part.number),
+					Body:                 bytes.NewReader(part.data),
+					ContentLength:        aws.Int64(int64(len(part.data))),
+					SSECustomerAlgorithm: alg,
+					SSECustomerKey:       key,
+					SSECustomerKeyMD5:    keyMD5,
+				})
+				if err != nil {
+					cancel(fmt.Errorf("upload part %d: %w", part.number, err))
+					continue
+				}
+
+				mu.Lock()
+				completed = append(completed, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(part.number)})
+				uploaded += int64(len(part.data))
+				if progress != nil {
+					progress(uploaded)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	var readErr error
+	data := first
+	for number := int32(1); len(data) > 0; number++ {
+		if number > maxParts {
+			readErr = fmt.Errorf("body exceeds %d parts of %d bytes", maxParts, c.partSize)
+			break
+		}
+
+		select {
+		case parts <- uploadPart{number: number, data: data}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+
+		if int64(len(data)) < c.partSize {
+			break
+		}
+
+		data, readErr = readPart(r, c.partSize)
+		if readErr != nil {
+			break
+		}
+	}
+	close(parts)
+	wg.Wait()
+
+	if readErr != nil {
+		return nil, readErr
+	}
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(completed, func(a, b types.CompletedPart) int {
+		return int(*a.PartNumber - *b.PartNumber)
+	})
+
+	return completed, nil
+}
+
+// Reads up to size bytes from r. A short or empty result means r is exhausted.
+func readPart(r io.Reader, size int64) ([]byte, error) {
+	buf := make([]byte, size)
+	n, err := io.ReadFull(r, buf)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
+	}
+	return buf[:n], nil
+}
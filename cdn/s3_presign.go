@@ -0,0 +1,126 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+const (
+	// SigV4 presigned URLs are valid for at most 7 days
+	maxPresignExpiry       = 7 * 24 * time.Hour
+	defaultSignedURLExpiry = time.Hour
+)
+
+// Presigning an SSE-C request would hand the customer key to whoever gets the URL
+var errPresignSSEC = errors.New("presigned urls can't be used with an sse customer key")
+
+type PresignUploadOptions struct {
+	ACL           string // overrides S3ClientConfig.ACL, the client must send it back in the x-amz-acl header
+	ContentType   string // when set the client must send this exact Content-Type
+	ContentLength int64  // when set the client must send exactly this many bytes
+}
+
+type PresignedUpload struct {
+	Filename string      // same as the filename UploadFile returns, store this once the upload succeeds
+	URL      string      // PUT the file body here
+	Method   string      // always PUT
+	Header   http.Header // headers the client must send along with the request
+	Expires  time.Time
+}
+
+func validatePresignExpiry(expires time.Duration) error {
+	if expires <= 0 || expires > maxPresignExpiry {
+		return fmt.Errorf("presign expiry %s is invalid: must be between 0 and %s", expires, maxPresignExpiry)
+	}
+	return nil
+}
+
+// Returns a presigned PUT URL a browser can upload a file to directly, keyed the same way as UploadFile.
+// With an UploadPolicy opts must set a ContentType that is allowed and matches ext, and a ContentLength
+// within MaxSize when it is set. The content itself isn't checked, so RejectActiveContent rejects
+// types that can run scripts, ex: SVG and HTML.
+func (c *S3Client) PresignUpload(ctx context.Context, ext string, expires time.Duration, opts PresignUploadOptions) (PresignedUpload, error) {
+	if err := validatePresignExpiry(expires); err != nil {
+		return PresignedUpload{}, err
+	}
+
+	if c.policy != nil {
+		if err := c.policy.validatePresign(ext, opts.ContentType, opts.ContentLength); err != nil {
+			return PresignedUpload{}, err
+		}
+	}
+
+	if c.sse.customer() {
+		return PresignedUpload{}, errPresignSSEC
+	}
+
+	acl, err := c.uploadACL(UploadOptions{ACL: opts.ACL})
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+
+	filename, err := newFilename(ctx, c.keys, nil, ext)
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+
+	// signed, so the browser has to send them, they are in PresignedUpload.Header
+	input := &s3.PutObjectInput{
+		Bucket:               aws.String(c.bucket),
+		Key:                  aws.String(c.objectKey(filename)),
+		ACL:                  acl,
+		ServerSideEncryption: c.sse.mode,
+		SSEKMSKeyId:          c.sse.kmsKeyId,
+	}
+	if len(opts.ContentType) > 0 {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	if opts.ContentLength > 0 {
+		input.ContentLength = aws.Int64(opts.ContentLength)
+	}
+
+	req, err := c.presign.PresignPutObject(ctx, input, s3.WithPresignExpires(expires))
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+
+	// the browser sets Host itself
+	header := req.SignedHeader.Clone()
+	header.Del("Host")
+
+	upload := PresignedUpload{
+		Filename: filename,
+		URL:      req.URL,
+		Method:   req.Method,
+		Header:   header,
+		Expires:  time.Now().Add(expires),
+	}
+
+	return upload, nil
+}
+
+// Returns a presigned GET URL for a filename returned by UploadFile. Works for private objects.
+func (c *S3Client) PresignDownload(ctx context.Context, filename string, expires time.Duration) (string, error) {
+	if err := validatePresignExpiry(expires); err != nil {
+		return "", err
+	}
+	if c.sse.customer() {
+		return "", errPresignSSEC
+	}
+
+	req, err := c.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(c.objectKey(filename)),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", err
+	}
+
+	return req.URL, nil
+}
@@ -0,0 +1,42 @@
+package cdn_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c-malecki/go-utils/cdn"
+	"github.com/c-malecki/go-utils/cdn/cdntest"
+)
+
+func TestPresignUploadPolicy(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	policy := cdn.ImageUploadPolicy
+	policy.AllowedTypes = append(policy.AllowedTypes, "image/svg+xml")
+	c := newS3Client(t, srv, cdn.S3ClientConfig{UploadPolicy: &policy})
+
+	for _, tc := range []struct {
+		name string
+		ext  string
+		opts cdn.PresignUploadOptions
+		err  error
+	}{
+		{"valid", "png", cdn.PresignUploadOptions{ContentType: "image/png", ContentLength: 1024}, nil},
+		{"no content type", "png", cdn.PresignUploadOptions{ContentLength: 1024}, cdn.ErrTypeNotAllowed},
+		{"no content length", "png", cdn.PresignUploadOptions{ContentType: "image/png"}, cdn.ErrFileTooLarge},
+		{"over max size", "png", cdn.PresignUploadOptions{ContentType: "image/png", ContentLength: policy.MaxSize + 1}, cdn.ErrFileTooLarge},
+		{"type not allowed", "png", cdn.PresignUploadOptions{ContentType: "text/html", ContentLength: 1024}, cdn.ErrTypeNotAllowed},
+		{"extension mismatch", "png", cdn.PresignUploadOptions{ContentType: "image/jpeg", ContentLength: 1024}, cdn.ErrExtensionMismatch},
+		{"active type", "svg", cdn.PresignUploadOptions{ContentType: "image/svg+xml", ContentLength: 1024}, cdn.ErrActiveContent},
+	} {
+		upload, err := c.PresignUpload(context.Background(), tc.ext, time.Minute, tc.opts)
+		if !errors.Is(err, tc.err) {
+			t.Fatalf("%s: err = %v, want %v", tc.name, err, tc.err)
+		}
+		if tc.err == nil && upload.Header.Get("Content-Type") != tc.opts.ContentType {
+			t.Fatalf("%s: signed headers %v", tc.name, upload.Header)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Streams an object's content. The caller must close the body.
+// Missing objects return an error wrapping ErrNotFound.
+func (c *S3Client) GetFile(ctx context.Context, filename string) (io.ReadCloser, ObjectMetadata, error) {
+	return c.getObject(ctx, filename, nil)
+}
+
+// Streams length bytes of an object starting at offset, length -1 reads to the end. A range
+// starting past the end of the object returns an error wrapping ErrInvalidRange, one ending past
+// it is shortened.
+func (c *S3Client) GetFileRange(ctx context.Context, filename string, offset int64, length int64) (io.ReadCloser, ObjectMetadata, error) {
+	if err := validateRange(offset, length); err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	byteRange := fmt.Sprintf("bytes=%d-", offset)
+	if length > 0 {
+		byteRange += strconv.FormatInt(offset+length-1, 10)
+	}
+
+	return c.getObject(ctx, filename, aws.String(byteRange))
+}
+
+func (c *S3Client) getObject(ctx context.Context, filename string, byteRange *string) (io.ReadCloser, ObjectMetadata, error) {
+	objectKey := c.objectKey(filename)
+
+	alg, key, keyMD5 := c.sse.customerHeaders()
+	out, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:               aws.String(c.bucket),
+		Key:                  aws.String(objectKey),
+		Range:                byteRange,
+		SSECustomerAlgorithm: alg,
+		SSECustomerKey:       key,
+		SSECustomerKeyMD5:    keyMD5,
+	})
+	if isNotFound(err) {
+		return nil, ObjectMetadata{}, notFound(objectKey)
+	}
+	var re *awshttp.ResponseError
+	if errors.As(err, &re) && re.HTTPStatusCode() == http.StatusRequestedRangeNotSatisfiable {
+		return nil, ObjectMetadata{}, fmt.Errorf("object \"%s\": %w", objectKey, ErrInvalidRange)
+	}
+	if err != nil {
+		return nil, ObjectMetadata{}, err
+	}
+
+	size := aws.ToInt64(out.ContentLength)
+	// ex: "bytes 0-99/1234", the part after the slash is the size of the whole object
+	if _, total, ok := strings.Cut(aws.ToString(out.ContentRange), "/"); ok {
+		if n, err := strconv.ParseInt(total, 10, 64); err == nil {
+			size = n
+		}
+	}
+
+	meta := ObjectMetadata{
+		ObjectInfo: ObjectInfo{
+			Filename:     filename,
+			Size:         size,
+			ETag:         trimETag(aws.ToString(out.ETag)),
+			LastModified: aws.ToTime(out.LastModified),
+		},
+		ContentType:        aws.ToString(out.ContentType),
+		CacheControl:       aws.ToString(out.CacheControl),
+		ContentDisposition: aws.ToString(out.ContentDisposition),
+		Metadata:           out.Metadata,
+		TagCount:           int(aws.ToInt32(out.TagCount)),
+	}
+
+	return out.Body, meta, nil
+}
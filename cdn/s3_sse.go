@@ -0,0 +1,66 @@
+package cdn
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const sseCustomerAlgorithm = "AES256"
+
+// Server side encryption settings sent with every write and, for SSE-C, every read.
+type serverSideEncryption struct {
+	mode     types.ServerSideEncryption
+	kmsKeyId *string
+	// base64 encoded SSE-C key and its MD5, nil without SSE-C
+	customerKey    *string
+	customerKeyMD5 *string
+}
+
+func newServerSideEncryption(config S3ClientConfig) (serverSideEncryption, error) {
+	var sse serverSideEncryption
+
+	if len(config.SSECustomerKey) > 0 {
+		if len(config.SSECustomerKey) != 32 {
+			return sse, fmt.Errorf("sse customer key is invalid: must be 32 bytes, got %d", len(config.SSECustomerKey))
+		}
+		if len(config.ServerSideEncryption) > 0 {
+			return sse, fmt.Errorf("server side encryption \"%s\" is invalid: can't be combined with an sse customer key", config.ServerSideEncryption)
+		}
+		sum := md5.Sum(config.SSECustomerKey)
+		sse.customerKey = aws.String(base64.StdEncoding.EncodeToString(config.SSECustomerKey))
+		sse.customerKeyMD5 = aws.String(base64.StdEncoding.EncodeToString(sum[:]))
+		return sse, nil
+	}
+
+	switch types.ServerSideEncryption(config.ServerSideEncryption) {
+	case "", types.ServerSideEncryptionAes256:
+		if len(config.SSEKMSKeyID) > 0 {
+			return sse, fmt.Errorf("sse kms key id requires server side encryption \"aws:kms\"")
+		}
+	case types.ServerSideEncryptionAwsKms, types.ServerSideEncryptionAwsKmsDsse:
+		if len(config.SSEKMSKeyID) > 0 {
+			sse.kmsKeyId = aws.String(config.SSEKMSKeyID)
+		}
+	default:
+		return sse, fmt.Errorf("server side encryption \"%s\" is invalid: must be \"AES256\", \"aws:kms\" or \"aws:kms:dsse\"", config.ServerSideEncryption)
+	}
+	sse.mode = types.ServerSideEncryption(config.ServerSideEncryption)
+
+	return sse, nil
+}
+
+func (sse serverSideEncryption) customer() bool {
+	return sse.customerKey != nil
+}
+
+// SSE-C algorithm, key and key MD5 for requests that read or write an object, all nil without SSE-C.
+func (sse serverSideEncryption) customerHeaders() (*string, *string, *string) {
+	if !sse.customer() {
+		return nil, nil, nil
+	}
+	return aws.String(sseCustomerAlgorithm), sse.customerKey, sse.customerKeyMD5
+}
@@ -0,0 +1,328 @@
+package cdn_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+	"github.com/c-malecki/go-utils/cdn/cdntest"
+)
+
+const testBucket = "assets"
+
+func newS3Client(t *testing.T, srv *cdntest.S3Server, config cdn.S3ClientConfig) *cdn.S3Client {
+	t.Helper()
+	config.S3Endpoint = srv.URL
+	config.S3PathStyle = true
+	config.S3Bucket = testBucket
+	config.S3Region = "us-east-1"
+	config.S3Key = "test"
+	config.S3Secret = "test"
+	if len(config.PublicURL) == 0 {
+		config.PublicURL = "https://cdn.example.com/"
+	}
+	c, err := cdn.CreateS3Client(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func objectACL(t *testing.T, srv *cdntest.S3Server, key string) string {
+	t.Helper()
+	obj, ok := srv.Object(testBucket, key)
+	if !ok {
+		t.Fatalf("object %s not found", key)
+	}
+	return obj.Header.Get("X-Amz-Acl")
+}
+
+func TestUploadAndDelete(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{PublicPrefix: "dev"})
+
+	filename, err := c.UploadFile([]byte("body{}"), "css", "text/css")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, ok := srv.Object(testBucket, "dev/"+filename)
+	if !ok {
+		t.Fatalf("dev/%s not stored", filename)
+	}
+	if string(obj.Data) != "body{}" || obj.Header.Get("Content-Type") != "text/css" {
+		t.Fatalf("stored %q as %q", obj.Data, obj.Header.Get("Content-Type"))
+	}
+
+	if err := c.DeleteFile(filename); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := srv.Object(testBucket, "dev/"+filename); ok {
+		t.Fatal("object still stored after DeleteFile")
+	}
+	if _, err := c.HeadFile(context.Background(), filename); !errors.Is(err, cdn.ErrNotFound) {
+		t.Fatalf("HeadFile after delete = %v, want ErrNotFound", err)
+	}
+}
+
+func TestListFilesPaginates(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{PublicPrefix: "dev"})
+
+	// the server returns at most 1000 keys per page
+	const n = 1005
+	for i := range n {
+		srv.PutObject(testBucket, cdntest.S3Object{Key: fmt.Sprintf("dev/list/%04d.txt", i), Data: []byte("x")})
+	}
+	srv.PutObject(testBucket, cdntest.S3Object{Key: "dev/other.txt", Data: []byte("x")})
+
+	var filenames []string
+	for obj, err := range c.ListFiles(context.Background(), "list/") {
+		if err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, obj.Filename)
+	}
+	if len(filenames) != n {
+		t.Fatalf("listed %d files, want %d", len(filenames), n)
+	}
+	if filenames[0] != "list/0000.txt" || filenames[n-1] != fmt.Sprintf("list/%04d.txt", n-1) {
+		t.Fatalf("listed %s ... %s", filenames[0], filenames[n-1])
+	}
+}
+
+func TestUploadStreamMultipart(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{MultipartPartSize: 5 << 20})
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (11<<20)/16)
+	var progress int64
+	filename, err := c.UploadStream(context.Background(), bytes.NewReader(data), "bin", "application/octet-stream", func(uploaded int64) {
+		progress = uploaded
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, ok := srv.Object(testBucket, filename)
+	if !ok || !bytes.Equal(obj.Data, data) {
+		t.Fatalf("%s not stored whole", filename)
+	}
+	if !strings.HasSuffix(obj.ETag, "-3\"") {
+		t.Fatalf("etag %s, want one of 3 parts", obj.ETag)
+	}
+	if progress != int64(len(data)) {
+		t.Fatalf("progress reported %d bytes, want %d", progress, len(data))
+	}
+}
+
+func TestUploadStreamAbortsOnFailure(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{MultipartPartSize: 5 << 20})
+
+	// parts are uploaded with PUT, CreateMultipartUpload and AbortMultipartUpload aren't
+	srv.FailNext(http.MethodPut, 100, http.StatusForbidden, "AccessDenied")
+
+	data := bytes.Repeat([]byte("x"), 11<<20)
+	if _, err := c.UploadStream(context.Background(), bytes.NewReader(data), "bin", "", nil); err == nil {
+		t.Fatal("UploadStream succeeded with failing parts")
+	}
+	if n := srv.PendingUploads(); n != 0 {
+		t.Fatalf("%d multipart uploads left pending", n)
+	}
+	if objects := srv.Objects(testBucket); len(objects) != 0 {
+		t.Fatalf("%d objects stored", len(objects))
+	}
+}
+
+func TestDeleteFiles(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{PublicPrefix: "dev"})
+
+	var filenames []string
+	for i := range 3 {
+		filename := fmt.Sprintf("doc-%d.txt", i)
+		if err := c.PutFile(filename, []byte("doc"), ""); err != nil {
+			t.Fatal(err)
+		}
+		filenames = append(filenames, filename)
+	}
+	if err := c.PutFile("keep.txt", []byte("keep"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := c.DeleteFiles(context.Background(), filenames)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != len(filenames) || len(res.Failed) != 0 {
+		t.Fatalf("deleted %v, failed %v", res.Deleted, res.Failed)
+	}
+	objects := srv.Objects(testBucket)
+	if len(objects) != 1 || objects[0].Key != "dev/keep.txt" {
+		t.Fatalf("left %v", objects)
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{})
+	ctx := context.Background()
+
+	opts := cdn.UploadOptions{
+		CacheControl: "no-cache",
+		Metadata:     map[string]string{"uploader-id": "42"},
+		Tags:         map[string]string{"kind": "report"},
+	}
+	if err := c.PutFileWithOptions(ctx, "report.csv", []byte("a,b\n"), "text/csv", opts); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.CopyFile(ctx, "report.csv", "archive/report.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := c.HeadFile(ctx, "archive/report.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.ContentType != "text/csv" || meta.CacheControl != "no-cache" || meta.Metadata["uploader-id"] != "42" {
+		t.Fatalf("copy metadata %+v", meta)
+	}
+	tags, err := c.GetFileTags(ctx, "archive/report.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tags["kind"] != "report" {
+		t.Fatalf("copy tags %v", tags)
+	}
+	if _, ok := srv.Object(testBucket, "report.csv"); !ok {
+		t.Fatal("source removed by CopyFile")
+	}
+
+	if err := c.CopyFile(ctx, "missing.csv", "copy.csv"); !errors.Is(err, cdn.ErrNotFound) {
+		t.Fatalf("copying a missing file = %v, want ErrNotFound", err)
+	}
+}
+
+func TestCopyFileKeepsACL(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{ACL: "public-read"})
+	ctx := context.Background()
+
+	if err := c.PutFileWithOptions(ctx, "contract.pdf", []byte("%PDF-1.7"), "", cdn.UploadOptions{ACL: "private"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.PutFile("logo.txt", []byte("logo"), ""); err != nil {
+		t.Fatal(err)
+	}
+
+	trash, err := cdn.CreateTrashClient(c, cdn.TrashConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.DeleteFile("contract.pdf"); err != nil {
+		t.Fatal(err)
+	}
+
+	var trashKey string
+	for _, obj := range srv.Objects(testBucket) {
+		if strings.HasPrefix(obj.Key, "trash/contract.pdf/") {
+			trashKey = obj.Key
+		}
+	}
+	if trashKey == "" {
+		t.Fatal("contract.pdf was not moved to the trash")
+	}
+	if acl := objectACL(t, srv, trashKey); acl != "private" {
+		t.Fatalf("trashed copy acl = %q, want private", acl)
+	}
+
+	if err := trash.Restore(ctx, "contract.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	if acl := objectACL(t, srv, "contract.pdf"); acl != "private" {
+		t.Fatalf("restored acl = %q, want private", acl)
+	}
+
+	if err := c.CopyFile(ctx, "logo.txt", "logo-copy.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if acl := objectACL(t, srv, "logo-copy.txt"); acl != "public-read" {
+		t.Fatalf("copy acl = %q, want public-read", acl)
+	}
+}
+
+func TestCopyFileWithoutObjectACLs(t *testing.T) {
+	for _, code := range []struct {
+		status int
+		code   string
+	}{
+		{http.StatusNotImplemented, "NotImplemented"},
+		{http.StatusForbidden, "AccessDenied"},
+	} {
+		srv := cdntest.NewS3Server(testBucket)
+		c := newS3Client(t, srv, cdn.S3ClientConfig{ACL: "private"})
+		ctx := context.Background()
+
+		if err := c.PutFile("report.csv", []byte("a,b"), ""); err != nil {
+			t.Fatal(err)
+		}
+		// CopyFile's only GET is GetObjectAcl, retries included
+		srv.FailNext(http.MethodGet, 10, code.status, code.code)
+		if err := c.CopyFile(ctx, "report.csv", "copy.csv"); err != nil {
+			t.Fatalf("%s: %v", code.code, err)
+		}
+		if acl := objectACL(t, srv, "copy.csv"); acl != "private" {
+			t.Fatalf("%s: copy acl = %q, want the configured private", code.code, acl)
+		}
+		srv.Close()
+	}
+}
+
+func TestDeleteLegacyKeys(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{PublicPrefix: "dev"})
+	ctx := context.Background()
+
+	// objects stored before the aws-sdk-go-v2 port live under filename/filename
+	for _, key := range []string{"dev/a.txt", "a.txt/a.txt", "users/1/b.txt/users/1/b.txt", "users/1/keep.txt"} {
+		srv.PutObject(testBucket, cdntest.S3Object{Key: key, Data: []byte("x")})
+	}
+
+	res, err := c.DeleteFiles(ctx, []string{"a.txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 1 || len(res.Failed) != 0 {
+		t.Fatalf("deleted %v, failed %v", res.Deleted, res.Failed)
+	}
+	for _, key := range []string{"dev/a.txt", "a.txt/a.txt"} {
+		if _, ok := srv.Object(testBucket, key); ok {
+			t.Fatalf("%s left after DeleteFiles", key)
+		}
+	}
+
+	res, err = c.DeletePrefix(ctx, "users/1/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 1 || res.Deleted[0] != "users/1/b.txt" {
+		t.Fatalf("DeletePrefix deleted %v", res.Deleted)
+	}
+	objects := srv.Objects(testBucket)
+	if len(objects) != 1 || objects[0].Key != "users/1/keep.txt" {
+		t.Fatalf("left %v", objects)
+	}
+}
@@ -0,0 +1,178 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"path"
+	"strings"
+	"time"
+)
+
+const defaultStagingPrefix = "tmp"
+
+type StagingConfig struct {
+	Prefix      string      // ex: tmp -> UploadFile returns tmp/1760745600-3f9a1c2b7d4e.png, default "tmp"
+	KeyStrategy KeyStrategy // names uploads below Prefix, default UniqueKeys
+}
+
+// Cdn whose uploads land under a temporary prefix until Commit promotes them, so files uploaded
+// from a form that is never saved can be removed by SweepStaging instead of becoming orphans.
+// UploadFile returns the temporary filename, which SetImage can build a preview URL for.
+// Everything else is passed through to the wrapped Cdn, except ListFiles which leaves out
+// uncommitted files.
+type StagingClient struct {
+	Cdn
+	prefix string
+	keys   KeyStrategy
+}
+
+var _ Cdn = (*StagingClient)(nil)
+
+func CreateStagingClient(c Cdn, config StagingConfig) (*StagingClient, error) {
+	if c == nil {
+		return nil, fmt.Errorf("cdn is required")
+	}
+	if len(config.Prefix) == 0 {
+		config.Prefix = defaultStagingPrefix
+	}
+	if !validateS3Key(config.Prefix) {
+		return nil, fmt.Errorf("staging prefix \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 _ -\" only", config.Prefix)
+	}
+
+	client := &StagingClient{
+		Cdn:    c,
+		prefix: config.Prefix,
+		keys:   config.KeyStrategy,
+	}
+
+	return client, nil
+}
+
+func (c *StagingClient) staged(filename string) bool {
+	return strings.HasPrefix(filename, c.prefix+"/")
+}
+
+func (c *StagingClient) UploadFile(data []byte, ext string, contentType string) (string, error) {
+	return c.UploadFileContext(context.Background(), data, ext, contentType)
+}
+
+// Uploads under the staging prefix. The filename below it is the one Commit promotes the file to.
+func (c *StagingClient) UploadFileContext(ctx context.Context, data []byte, ext string, contentType string) (string, error) {
+	filename, err := newFilename(ctx, c.keys, data, ext)
+	if err != nil {
+		return "", err
+	}
+
+	staged := c.prefix + "/" + filename
+	if err := c.Cdn.PutFileContext(ctx, staged, data, contentType); err != nil {
+		return "", err
+	}
+
+	return staged, nil
+}
+
+// Moves a file returned by UploadFile to its permanent filename and returns it, call it once the
+// filename is saved. Variants UploadImage stored next to it are moved along, ex: tmp/x.jpg also
+// promotes tmp/x_64.jpg and tmp/x_256.jpg. Filenames that aren't staged are returned as is, so
+// saving a form again without a new upload is a no-op, and so is committing the same file twice.
+// Files removed by SweepStaging return an error wrapping ErrNotFound.
+func (c *StagingClient) Commit(ctx context.Context, filename string) (string, error) {
+	if !c.staged(filename) {
+		return filename, nil
+	}
+	permanent := strings.TrimPrefix(filename, c.prefix+"/")
+
+	variants, err := c.stagedVariants(ctx, filename)
+	if err != nil {
+		return "", fmt.Errorf("commit: %w", err)
+	}
+	// variants go first so a retried Commit still finds the original staged until they are all copied
+	for _, v := range variants {
+		if err := copyFile(ctx, c.Cdn, v, strings.TrimPrefix(v, c.prefix+"/")); err != nil {
+			return "", fmt.Errorf("commit variant: %w", err)
+		}
+	}
+
+	err = copyFile(ctx, c.Cdn, filename, permanent)
+	if errors.Is(err, ErrNotFound) {
+		// a previous Commit may have copied and deleted it already
+		if exists, existsErr := FileExists(ctx, c.Cdn, permanent); existsErr == nil && exists {
+			return permanent, nil
+		}
+		return "", err
+	}
+	if err != nil {
+		return "", fmt.Errorf("commit: %w", err)
+	}
+
+	// the permanent copies exist so the commit succeeded, staged copies left behind are swept later
+	for _, v := range variants {
+		c.Cdn.DeleteFileContext(ctx, v)
+	}
+	c.Cdn.DeleteFileContext(ctx, filename)
+
+	return permanent, nil
+}
+
+// Lists the staged variants of filename, named as VariantFilename names them.
+func (c *StagingClient) stagedVariants(ctx context.Context, filename string) ([]string, error) {
+	ext := path.Ext(filename)
+	base := strings.TrimSuffix(filename, ext) + "_"
+
+	var variants []string
+	for obj, err := range c.Cdn.ListFiles(ctx, base) {
+		if err != nil {
+			return nil, err
+		}
+		vext := path.Ext(obj.Filename)
+		name := strings.TrimSuffix(strings.TrimPrefix(obj.Filename, base), vext)
+		if vext == variantExt(ext) && variantNameRe.MatchString(name) {
+			variants = append(variants, obj.Filename)
+		}
+	}
+
+	return variants, nil
+}
+
+func (c *StagingClient) ListFiles(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		for obj, err := range c.Cdn.ListFiles(ctx, prefix) {
+			if err == nil && c.staged(obj.Filename) {
+				continue
+			}
+			if !yield(obj, err) {
+				return
+			}
+		}
+	}
+}
+
+// Deletes staged files uploaded more than ttl ago that were never committed, ex: 24 * time.Hour.
+// The ttl must be longer than a user may take to fill in the form. With dryRun nothing is deleted
+// and DeleteResult.Deleted lists what would have been. ttl must be positive unless dryRun is set.
+func (c *StagingClient) SweepStaging(ctx context.Context, ttl time.Duration, dryRun bool) (DeleteResult, error) {
+	var result DeleteResult
+	if ttl <= 0 && !dryRun {
+		return result, fmt.Errorf("ttl %s is invalid: must be positive unless dryRun is set", ttl)
+	}
+	cutoff := time.Now().Add(-ttl)
+
+	var expired []string
+	for obj, err := range c.Cdn.ListFiles(ctx, c.prefix+"/") {
+		if err != nil {
+			return result, err
+		}
+		if obj.LastModified.Before(cutoff) {
+			expired = append(expired, obj.Filename)
+		}
+	}
+
+	if dryRun || len(expired) == 0 {
+		result.Deleted = expired
+		return result, nil
+	}
+
+	return deleteFiles(ctx, c.Cdn, expired)
+}
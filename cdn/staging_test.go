@@ -0,0 +1,103 @@
+package cdn_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c-malecki/go-utils/cdn"
+)
+
+func newStagingClient(t *testing.T, c cdn.Cdn) *cdn.StagingClient {
+	t.Helper()
+	staging, err := cdn.CreateStagingClient(c, cdn.StagingConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return staging
+}
+
+func TestSweepStagingRequiresTTL(t *testing.T) {
+	m := newMemoryClient(t)
+	staging := newStagingClient(t, m)
+	ctx := context.Background()
+
+	if _, err := staging.UploadFile([]byte("draft"), "txt", "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ttl := range []time.Duration{0, -time.Hour} {
+		if _, err := staging.SweepStaging(ctx, ttl, false); err == nil {
+			t.Fatalf("SweepStaging ran with ttl %s", ttl)
+		}
+	}
+	res, err := staging.SweepStaging(ctx, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 1 || len(m.Objects()) != 1 {
+		t.Fatalf("dry run reported %v and left %d objects", res.Deleted, len(m.Objects()))
+	}
+}
+
+func TestCommitPromotesVariants(t *testing.T) {
+	m := newMemoryClient(t)
+	staging := newStagingClient(t, m)
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 300, 200))); err != nil {
+		t.Fatal(err)
+	}
+	up, err := cdn.UploadImage(ctx, staging, buf.Bytes(), "png", cdn.DefaultImageVariants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(up.Filename, "tmp/") || len(up.Variants) != 3 {
+		t.Fatalf("uploaded %+v", up)
+	}
+	// same base name but not a variant, left staged
+	notes := strings.TrimSuffix(up.Filename, ".png") + "_notes.txt"
+	if err := m.PutFile(notes, []byte("x"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+
+	permanent, err := staging.Commit(ctx, up.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{notes, permanent}
+	for _, v := range cdn.DefaultImageVariants {
+		want = append(want, cdn.VariantFilename(permanent, v.Name))
+	}
+	var got []string
+	for _, obj := range m.Objects() {
+		got = append(got, obj.Filename)
+	}
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("after Commit %v, want %v", got, want)
+	}
+
+	// committing again, from a retried request or a form saved twice, is a no-op
+	for _, filename := range []string{up.Filename, permanent} {
+		again, err := staging.Commit(ctx, filename)
+		if err != nil || again != permanent {
+			t.Fatalf("Commit(%s) again = %s, %v, want %s", filename, again, err, permanent)
+		}
+	}
+	if n := len(m.Objects()); n != len(want) {
+		t.Fatalf("%d objects after committing again, want %d", n, len(want))
+	}
+
+	if _, err := staging.Commit(ctx, "tmp/swept.png"); !errors.Is(err, cdn.ErrNotFound) {
+		t.Fatalf("Commit of a swept file = %v, want ErrNotFound", err)
+	}
+}
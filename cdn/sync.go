@@ -0,0 +1,219 @@
+package cdn
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/c-malecki/go-utils/path"
+)
+
+type SyncConfig struct {
+	Concurrency int  // files compared and uploaded in parallel, default 4
+	DryRun      bool // report what would be uploaded and deleted without changing anything
+	// Delete objects under the prefix that have no local file, by default they are kept. Requires a prefix
+	Delete bool
+}
+
+type SyncReport struct {
+	Uploaded  []string // filenames, for a dry run the ones that would have been uploaded
+	Unchanged int      // remote object has the same size and MD5
+	Deleted   []string // for a dry run the ones that would have been deleted
+	Failed    map[string]error
+}
+
+// Uploads the files of localDir under prefix, ex: Sync(ctx, c, "./public", "assets", config) uploads
+// ./public/css/app.css as assets/css/app.css. Files whose object has the same size and an ETag
+// matching their MD5 are skipped, so only changed files are uploaded. Objects uploaded in multiple
+// parts don't have an MD5 ETag and are uploaded again once.
+// Content types come from the file extension, falling back to sniffing the content. Files are streamed
+// to backends that support it, ex: S3Client, and read whole for the others.
+// Hidden files and directories, ex: .env or .git/config, are neither uploaded nor deleted.
+func Sync(ctx context.Context, c Cdn, localDir string, prefix string, config SyncConfig) (SyncReport, error) {
+	report := SyncReport{Failed: make(map[string]error)}
+
+	prefix = strings.Trim(prefix, "/")
+	if len(prefix) > 0 {
+		if err := validateFilename(prefix); err != nil {
+			return report, fmt.Errorf("prefix: %w", err)
+		}
+	}
+	if config.Delete && len(prefix) == 0 {
+		// every object of the bucket without a local file would be deleted
+		return report, fmt.Errorf("prefix is required to delete")
+	}
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaultConcurrency
+	}
+
+	paths, err := path.PathsForFilesInDir(localDir, "")
+	if err != nil {
+		return report, err
+	}
+
+	remote := make(map[string]ObjectInfo)
+	listPrefix := ""
+	if len(prefix) > 0 {
+		listPrefix = prefix + "/"
+	}
+	for obj, err := range c.ListFiles(ctx, listPrefix) {
+		if err != nil {
+			return report, fmt.Errorf("list files: %w", err)
+		}
+		if !hidden(obj.Filename) {
+			remote[obj.Filename] = obj
+		}
+	}
+
+	var (
+		wg  sync.WaitGroup
+		mu  sync.Mutex
+		sem = make(chan struct{}, config.Concurrency)
+	)
+
+	upload := func(filename string, localPath string, obj ObjectInfo, exists bool) {
+		defer wg.Done()
+		defer func() { <-sem }()
+
+		uploaded, err := syncFile(ctx, c, filename, localPath, obj, exists, config.DryRun)
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			report.Failed[filename] = err
+		case uploaded:
+			report.Uploaded = append(report.Uploaded, filename)
+		default:
+			report.Unchanged++
+		}
+	}
+
+	local := make(map[string]struct{}, len(paths))
+	for _, localPath := range paths {
+		if ctx.Err() != nil {
+			break
+		}
+
+		rel, err := filepath.Rel(localDir, localPath)
+		if err != nil {
+			report.Failed[localPath] = err
+			continue
+		}
+		if hidden(filepath.ToSlash(rel)) {
+			continue
+		}
+		filename := joinPrefix(prefix, filepath.ToSlash(rel))
+		local[filename] = struct{}{}
+
+		if err := validateFilename(filename); err != nil {
+			report.Failed[filename] = err
+			continue
+		}
+
+		obj, exists := remote[filename]
+		sem <- struct{}{}
+		wg.Add(1)
+		go upload(filename, localPath, obj, exists)
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return report, err
+	}
+	if !config.Delete {
+		return report, nil
+	}
+
+	var extras []string
+	for filename := range remote {
+		if _, ok := local[filename]; !ok {
+			extras = append(extras, filename)
+		}
+	}
+	if config.DryRun || len(extras) == 0 {
+		report.Deleted = extras
+		return report, nil
+	}
+
+	res, err := deleteFiles(ctx, c, extras)
+	report.Deleted = res.Deleted
+	for _, failure := range res.Failed {
+		report.Failed[failure.Filename] = fmt.Errorf("delete: %s", failure.Message)
+	}
+
+	return report, err
+}
+
+// Whether any segment of a slash separated path starts with a dot.
+func hidden(p string) bool {
+	for segment := range strings.SplitSeq(p, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Uploads localPath as filename unless obj, the existing object, has the same content.
+// Returns whether the file was or, for a dry run, would have been uploaded.
+func syncFile(ctx context.Context, c Cdn, filename string, localPath string, obj ObjectInfo, exists bool, dryRun bool) (bool, error) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if exists && obj.Size == info.Size() {
+		h := md5.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return false, err
+		}
+		if hex.EncodeToString(h.Sum(nil)) == obj.ETag {
+			return false, nil
+		}
+	}
+
+	if dryRun {
+		return true, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	// backends that take a stream sniff types the extension doesn't give themselves
+	contentType := mime.TypeByExtension(filepath.Ext(localPath))
+	if p, ok := c.(streamPutter); ok {
+		if err := p.PutStreamWithOptions(ctx, filename, f, contentType, UploadOptions{}, nil); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return false, err
+	}
+	if len(contentType) == 0 {
+		contentType = DetectContentType(data)
+	}
+
+	if err := c.PutFileContext(ctx, filename, data, contentType); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,94 @@
+package cdn_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/c-malecki/go-utils/cdn"
+	"github.com/c-malecki/go-utils/cdn/cdntest"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSync(t *testing.T) {
+	srv := cdntest.NewS3Server(testBucket)
+	defer srv.Close()
+	c := newS3Client(t, srv, cdn.S3ClientConfig{})
+	ctx := context.Background()
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"app.css":     "body{}",
+		"js/app.js":   "run()",
+		".env":        "SECRET=1",
+		".git/config": "[core]",
+	})
+
+	report, err := cdn.Sync(ctx, c, dir, "assets", cdn.SyncConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(report.Uploaded)
+	if !slices.Equal(report.Uploaded, []string{"assets/app.css", "assets/js/app.js"}) || len(report.Failed) != 0 {
+		t.Fatalf("uploaded %v, failed %v", report.Uploaded, report.Failed)
+	}
+	obj, _ := srv.Object(testBucket, "assets/app.css")
+	if ct := obj.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/css") {
+		t.Fatalf("app.css stored as %q", ct)
+	}
+
+	report, err = cdn.Sync(ctx, c, dir, "assets", cdn.SyncConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Uploaded) != 0 || report.Unchanged != 2 {
+		t.Fatalf("second sync uploaded %v, %d unchanged", report.Uploaded, report.Unchanged)
+	}
+
+	// changed content of the same size is uploaded again
+	writeFiles(t, dir, map[string]string{"app.css": "BODY{}"})
+	if err := os.Remove(filepath.Join(dir, "js", "app.js")); err != nil {
+		t.Fatal(err)
+	}
+	srv.PutObject(testBucket, cdntest.S3Object{Key: "assets/.well-known/security.txt", Data: []byte("x")})
+
+	if _, err := cdn.Sync(ctx, c, dir, "", cdn.SyncConfig{Delete: true}); err == nil {
+		t.Fatal("Sync deleted without a prefix")
+	}
+	report, err = cdn.Sync(ctx, c, dir, "assets", cdn.SyncConfig{Delete: true, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(report.Uploaded, []string{"assets/app.css"}) || !slices.Equal(report.Deleted, []string{"assets/js/app.js"}) {
+		t.Fatalf("dry run would upload %v and delete %v", report.Uploaded, report.Deleted)
+	}
+	if obj, _ := srv.Object(testBucket, "assets/app.css"); string(obj.Data) != "body{}" {
+		t.Fatal("dry run uploaded")
+	}
+
+	if _, err := cdn.Sync(ctx, c, dir, "assets", cdn.SyncConfig{Delete: true}); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range srv.Objects(testBucket) {
+		keys = append(keys, obj.Key)
+	}
+	if !slices.Equal(keys, []string{"assets/.well-known/security.txt", "assets/app.css"}) {
+		t.Fatalf("bucket holds %v", keys)
+	}
+}
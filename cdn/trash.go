@@ -0,0 +1,195 @@
+package cdn
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"strings"
+	"time"
+)
+
+const (
+	defaultTrashPrefix = "trash"
+	trashTimeFormat    = "20060102T150405.000Z"
+)
+
+type TrashConfig struct {
+	Prefix string // ex: trash -> a.png is moved to trash/a.png/<deletion time>, default "trash"
+}
+
+type TrashedFile struct {
+	Filename  string // where the file was before it was deleted
+	Key       string // filename of the trashed copy in the wrapped Cdn
+	DeletedAt time.Time
+	Size      int64
+}
+
+// Cdn whose deletes move files to a trash prefix instead of removing them, so they can be brought
+// back with Restore until PurgeTrash removes them for good. Everything else is passed through to the
+// wrapped Cdn, except ListFiles which leaves out the trash.
+type TrashClient struct {
+	Cdn
+	prefix string
+}
+
+var _ Cdn = (*TrashClient)(nil)
+
+func CreateTrashClient(c Cdn, config TrashConfig) (*TrashClient, error) {
+	if c == nil {
+		return nil, fmt.Errorf("cdn is required")
+	}
+	if len(config.Prefix) == 0 {
+		config.Prefix = defaultTrashPrefix
+	}
+	if !validateS3Key(config.Prefix) {
+		return nil, fmt.Errorf("trash prefix \"%s\" is invalid: must contain characters \"a-z A-Z 0-9 _ -\" only", config.Prefix)
+	}
+
+	client := &TrashClient{
+		Cdn:    c,
+		prefix: config.Prefix,
+	}
+
+	return client, nil
+}
+
+func (c *TrashClient) inTrash(filename string) bool {
+	return strings.HasPrefix(filename, c.prefix+"/")
+}
+
+func (c *TrashClient) trashKey(filename string, deletedAt time.Time) string {
+	return c.prefix + "/" + filename + "/" + deletedAt.UTC().Format(trashTimeFormat)
+}
+
+// Parses a trashed copy's filename back into what was deleted and when.
+func (c *TrashClient) parseTrashKey(key string) (string, time.Time, bool) {
+	rest, ok := strings.CutPrefix(key, c.prefix+"/")
+	if !ok {
+		return "", time.Time{}, false
+	}
+	i := strings.LastIndex(rest, "/")
+	if i <= 0 {
+		return "", time.Time{}, false
+	}
+	deletedAt, err := time.Parse(trashTimeFormat, rest[i+1:])
+	if err != nil {
+		return "", time.Time{}, false
+	}
+	return rest[:i], deletedAt, true
+}
+
+func (c *TrashClient) DeleteFile(filename string) error {
+	return c.DeleteFileContext(context.Background(), filename)
+}
+
+// Moves filename to the trash. Missing files are ignored like DeleteFile does, files already in
+// the trash are deleted permanently.
+func (c *TrashClient) DeleteFileContext(ctx context.Context, filename string) error {
+	if c.inTrash(filename) {
+		return c.Cdn.DeleteFileContext(ctx, filename)
+	}
+
+	err := copyFile(ctx, c.Cdn, filename, c.trashKey(filename, time.Now()))
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("move to trash: %w", err)
+	}
+
+	return c.Cdn.DeleteFileContext(ctx, filename)
+}
+
+func (c *TrashClient) ListFiles(ctx context.Context, prefix string) iter.Seq2[ObjectInfo, error] {
+	return func(yield func(ObjectInfo, error) bool) {
+		for obj, err := range c.Cdn.ListFiles(ctx, prefix) {
+			if err == nil && c.inTrash(obj.Filename) {
+				continue
+			}
+			if !yield(obj, err) {
+				return
+			}
+		}
+	}
+}
+
+// Iterates trashed files whose original filename starts with prefix, "" for the whole trash.
+func (c *TrashClient) ListTrash(ctx context.Context, prefix string) iter.Seq2[TrashedFile, error] {
+	return func(yield func(TrashedFile, error) bool) {
+		for obj, err := range c.Cdn.ListFiles(ctx, c.prefix+"/"+prefix) {
+			if err != nil {
+				yield(TrashedFile{}, err)
+				return
+			}
+			filename, deletedAt, ok := c.parseTrashKey(obj.Filename)
+			if !ok || !strings.HasPrefix(filename, prefix) {
+				continue
+			}
+			file := TrashedFile{Filename: filename, DeletedAt: deletedAt, Size: obj.Size, Key: obj.Filename}
+			if !yield(file, nil) {
+				return
+			}
+		}
+	}
+}
+
+// Moves the most recently deleted copy of filename back out of the trash. Fails with an error
+// wrapping ErrNotFound if there is none and refuses to overwrite a file uploaded under the same
+// filename since.
+func (c *TrashClient) Restore(ctx context.Context, filename string) error {
+	var latest TrashedFile
+	for file, err := range c.ListTrash(ctx, filename) {
+		if err != nil {
+			return err
+		}
+		if file.Filename == filename && file.DeletedAt.After(latest.DeletedAt) {
+			latest = file
+		}
+	}
+	if len(latest.Key) == 0 {
+		return fmt.Errorf("trash: %w", notFound(filename))
+	}
+
+	exists, err := FileExists(ctx, c.Cdn, filename)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("file \"%s\" exists, delete it before restoring", filename)
+	}
+
+	if err := copyFile(ctx, c.Cdn, latest.Key, filename); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	return c.Cdn.DeleteFileContext(ctx, latest.Key)
+}
+
+// Permanently deletes files that have been in the trash longer than olderThan,
+// ex: 30 * 24 * time.Hour. With dryRun nothing is deleted and DeleteResult.Deleted lists the
+// trashed copies that would have been. olderThan must be positive unless dryRun is set.
+func (c *TrashClient) PurgeTrash(ctx context.Context, olderThan time.Duration, dryRun bool) (DeleteResult, error) {
+	var result DeleteResult
+	if olderThan <= 0 && !dryRun {
+		return result, fmt.Errorf("older than %s is invalid: must be positive unless dryRun is set", olderThan)
+	}
+	cutoff := time.Now().Add(-olderThan)
+
+	var expired []string
+	for file, err := range c.ListTrash(ctx, "") {
+		if err != nil {
+			return result, err
+		}
+		if file.DeletedAt.Before(cutoff) {
+			expired = append(expired, file.Key)
+		}
+	}
+
+	if dryRun || len(expired) == 0 {
+		result.Deleted = expired
+		return result, nil
+	}
+
+	return deleteFiles(ctx, c.Cdn, expired)
+}
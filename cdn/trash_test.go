@@ -0,0 +1,37 @@
+package cdn_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/c-malecki/go-utils/cdn"
+)
+
+func TestPurgeTrashRequiresAge(t *testing.T) {
+	m := newMemoryClient(t)
+	trash, err := cdn.CreateTrashClient(m, cdn.TrashConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.PutFile("a.txt", []byte("x"), "text/plain"); err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.DeleteFile("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, olderThan := range []time.Duration{0, -time.Hour} {
+		if _, err := trash.PurgeTrash(ctx, olderThan, false); err == nil {
+			t.Fatalf("PurgeTrash ran with olderThan %s", olderThan)
+		}
+	}
+	res, err := trash.PurgeTrash(ctx, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Deleted) != 1 || len(m.Objects()) != 1 {
+		t.Fatalf("dry run reported %v and left %d objects", res.Deleted, len(m.Objects()))
+	}
+}
@@ -2,6 +2,7 @@ package gen
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"time"
@@ -16,3 +17,9 @@ func GenerateUniqueFilename(extension string) string {
 	filename := fmt.Sprintf("%d-%s", timestamp, randomStr)
 	return filename + "." + extension
 }
+
+// Names a file by the SHA-256 of its content so identical files always get the same filename
+func GenerateContentFilename(data []byte, extension string) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]) + "." + extension
+}
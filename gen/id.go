@@ -0,0 +1,61 @@
+package gen
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"time"
+)
+
+// Crockford's base32, ULIDs use it so they sort the same as strings and as bytes
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// Returns a random UUID version 7 (RFC 9562), its leading 48 bits are the unix time in milliseconds
+// so IDs generated later sort after earlier ones.
+// ex: 019a2f4e-7c3b-7d2a-9f1e-3b5c8a0d4e6f
+func GenerateUUIDv7() string {
+	var b [16]byte
+	rand.Read(b[6:])
+	putMillis(b[:6], time.Now())
+	b[6] = b[6]&0x0f | 0x70 // version 7
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+
+	var s [36]byte
+	hex.Encode(s[0:8], b[0:4])
+	s[8] = '-'
+	hex.Encode(s[9:13], b[4:6])
+	s[13] = '-'
+	hex.Encode(s[14:18], b[6:8])
+	s[18] = '-'
+	hex.Encode(s[19:23], b[8:10])
+	s[23] = '-'
+	hex.Encode(s[24:], b[10:])
+	return string(s[:])
+}
+
+// Returns a ULID, 26 characters of which the first 10 encode the unix time in milliseconds and the
+// rest 80 random bits. ex: 01K8QWX5S2M3ZP7T4R9B6N1C0D
+func GenerateULID() string {
+	var b [16]byte
+	putMillis(b[:6], time.Now())
+	rand.Read(b[6:])
+
+	// 128 bits as 26 base32 digits, the first digit only holds 3 bits
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
+
+func putMillis(b []byte, t time.Time) {
+	ms := uint64(t.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		b[i] = byte(ms)
+		ms >>= 8
+	}
+}
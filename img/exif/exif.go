@@ -0,0 +1,145 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+var ErrMalformed = errors.New("image is malformed")
+
+const orientationTag = 0x0112
+
+// EXIF orientation of a JPEG, PNG or WebP image, 1 (upright) when it has none or the format is unknown.
+func Orientation(data []byte) int {
+	p, err := parse(data)
+	if err != nil {
+		return 1
+	}
+	return tiffOrientation(p.exif)
+}
+
+// Reads the orientation tag from IFD0 of EXIF data, with or without the "Exif\0\0" header JPEG uses.
+func tiffOrientation(b []byte) int {
+	b = bytes.TrimPrefix(b, []byte("Exif\x00\x00"))
+	if len(b) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(b[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int64(order.Uint32(b[4:8]))
+	if ifd+2 > int64(len(b)) {
+		return 1
+	}
+	n := int(order.Uint16(b[ifd:]))
+	for i := range n {
+		e := int(ifd) + 2 + i*12
+		if e+12 > len(b) {
+			return 1
+		}
+		// type 3 is SHORT, the only type the orientation tag uses
+		if order.Uint16(b[e:]) == orientationTag && order.Uint16(b[e+2:]) == 3 {
+			o := int(order.Uint16(b[e+8:]))
+			if o < 1 || o > 8 {
+				return 1
+			}
+			return o
+		}
+	}
+
+	return 1
+}
+
+// Smallest EXIF that only holds an orientation, little endian with a single IFD0 entry.
+func orientationExif(o int) []byte {
+	b := make([]byte, 26)
+	copy(b, "II*\x00")
+	binary.LittleEndian.PutUint32(b[4:], 8)
+	binary.LittleEndian.PutUint16(b[8:], 1)
+	binary.LittleEndian.PutUint16(b[10:], orientationTag)
+	binary.LittleEndian.PutUint16(b[12:], 3)
+	binary.LittleEndian.PutUint32(b[14:], 1)
+	binary.LittleEndian.PutUint16(b[18:], uint16(o))
+	return b
+}
+
+// Rotates and flips src so it displays upright without its EXIF orientation.
+// Orientations other than 2-8 return src as is. Images with 16 bits per channel keep them.
+func Orient(src image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	var (
+		inPix, outPix []byte
+		inStride      int
+		outStride     int
+		size          = 4
+		out           image.Image
+	)
+	if deep(src) {
+		in := image.NewNRGBA64(image.Rect(0, 0, w, h))
+		draw.Draw(in, in.Bounds(), src, b.Min, draw.Src)
+		dst := image.NewNRGBA64(image.Rect(0, 0, dw, dh))
+		inPix, inStride, outPix, outStride, size, out = in.Pix, in.Stride, dst.Pix, dst.Stride, 8, dst
+	} else {
+		in := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(in, in.Bounds(), src, b.Min, draw.Src)
+		dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+		inPix, inStride, outPix, outStride, out = in.Pix, in.Stride, dst.Pix, dst.Stride, dst
+	}
+
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // transversed
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter clockwise to display
+				dx, dy = y, w-1-x
+			}
+			copy(outPix[dy*outStride+dx*size:][:size], inPix[y*inStride+x*size:][:size])
+		}
+	}
+
+	return out
+}
+
+// Whether src has 16 bits per channel, as 16 bit PNGs decode.
+func deep(src image.Image) bool {
+	switch src.ColorModel() {
+	case color.RGBA64Model, color.NRGBA64Model, color.Gray16Model:
+		return true
+	default:
+		return false
+	}
+}
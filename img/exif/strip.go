@@ -0,0 +1,319 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"slices"
+)
+
+const jpegQuality = 90
+
+// An image split into what Strip keeps and the metadata it removes.
+type parsed struct {
+	format   string // "jpeg", "png" or "webp", empty for anything else
+	kept     []byte // the image without its metadata
+	exif     []byte // EXIF of the image, nil without
+	stripped bool   // whether kept is missing anything
+	color    []byte // JPEG ICC segments or PNG color chunks, re-inserted after re-encoding
+}
+
+func parse(data []byte) (parsed, error) {
+	switch {
+	case bytes.HasPrefix(data, []byte("\xff\xd8\xff")):
+		return parseJPEG(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return parsePNG(data)
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return parseWebP(data)
+	default:
+		return parsed{}, nil
+	}
+}
+
+// Removes EXIF, XMP, IPTC, comments and text chunks from a JPEG, PNG or WebP image, along with
+// anything appended after the image such as the extra pictures phones store after a JPEG.
+// Color profiles are kept. Images without metadata and other formats are returned as is.
+//
+// Metadata is cut out without re-encoding, except for JPEG and PNG images with an EXIF orientation
+// which are decoded, rotated upright and re-encoded so they still display the right way up.
+// Re-encoded images get their ICC profile back, and PNGs their sRGB, gAMA and cHRM chunks, except
+// grayscale and CMYK images which are re-encoded as RGB the profile no longer describes. 16 bit
+// PNGs stay 16 bit.
+// WebP can't be encoded with the standard library, so rotated WebP images keep an EXIF holding
+// only their orientation.
+func Strip(data []byte) ([]byte, error) {
+	p, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if !p.stripped {
+		return data, nil
+	}
+
+	o := tiffOrientation(p.exif)
+	switch {
+	case p.format == "webp":
+		return finishWebP(p.kept, o), nil
+	case o == 1:
+		return p.kept, nil
+	default:
+		return reencode(p, o)
+	}
+}
+
+func reencode(p parsed, orientation int) ([]byte, error) {
+	src, _, err := image.Decode(bytes.NewReader(p.kept))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrMalformed, err)
+	}
+	dst := Orient(src, orientation)
+
+	var buf bytes.Buffer
+	// the color segments go after the SOI marker of a JPEG and after the IHDR chunk of a PNG
+	at := 2
+	if p.format == "png" {
+		err = png.Encode(&buf, dst)
+		at = 33
+	} else {
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	out := buf.Bytes()
+	if len(p.color) == 0 || !rgb(src) {
+		return out, nil
+	}
+	return slices.Concat(out[:at], p.color, out[at:]), nil
+}
+
+// Whether src has RGB channels, so a color profile of the source still describes the re-encoded image.
+func rgb(src image.Image) bool {
+	switch src.ColorModel() {
+	case color.GrayModel, color.Gray16Model, color.CMYKModel:
+		return false
+	default:
+		return true
+	}
+}
+
+func malformed(format string, detail string) error {
+	return fmt.Errorf("%w: %s %s", ErrMalformed, format, detail)
+}
+
+// APP0 JFIF, APP2 ICC profiles and APP14 Adobe color transforms are needed to display the image,
+// every other APPn segment and comments are metadata.
+func jpegMetadata(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xfe:
+		return true
+	case marker == 0xe0:
+		return !bytes.HasPrefix(payload, []byte("JFIF\x00"))
+	case marker == 0xe2:
+		return !bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))
+	case marker == 0xee:
+		return !bytes.HasPrefix(payload, []byte("Adobe"))
+	default:
+		return marker >= 0xe1 && marker <= 0xef
+	}
+}
+
+func parseJPEG(data []byte) (parsed, error) {
+	p := parsed{format: "jpeg", kept: make([]byte, 0, len(data))}
+	p.kept = append(p.kept, data[:2]...)
+
+	i := 2
+	for {
+		if i+2 > len(data) || data[i] != 0xff {
+			return parsed{}, malformed("jpeg", "segment")
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xff: // fill byte
+			i++
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7):
+			p.kept = append(p.kept, data[i:i+2]...)
+			i += 2
+			continue
+		case marker == 0xda: // start of scan, the rest is image data
+			end, err := jpegEnd(data, i)
+			if err != nil {
+				return parsed{}, err
+			}
+			p.kept = append(p.kept, data[i:end]...)
+			p.stripped = p.stripped || end < len(data)
+			return p, nil
+		case marker == 0xd9:
+			return parsed{}, malformed("jpeg", "has no image data")
+		}
+
+		if i+4 > len(data) {
+			return parsed{}, malformed("jpeg", "segment")
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end < i+4 || end > len(data) {
+			return parsed{}, malformed("jpeg", "segment length")
+		}
+
+		payload := data[i+4 : end]
+		if jpegMetadata(marker, payload) {
+			if marker == 0xe1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) && p.exif == nil {
+				p.exif = payload
+			}
+			p.stripped = true
+		} else {
+			p.kept = append(p.kept, data[i:end]...)
+			if marker == 0xe2 {
+				p.color = append(p.color, data[i:end]...)
+			}
+		}
+		i = end
+	}
+}
+
+// Offset just past the end of image marker, skipping entropy coded data and the tables between
+// progressive scans. Images missing the marker end at the end of data.
+func jpegEnd(data []byte, i int) (int, error) {
+	for i+1 < len(data) {
+		if data[i] != 0xff {
+			i++
+			continue
+		}
+		switch marker := data[i+1]; {
+		case marker == 0xd9:
+			return i + 2, nil
+		case marker == 0xff: // fill byte
+			i++
+		case marker == 0x00 || (marker >= 0xd0 && marker <= 0xd7): // stuffed byte or restart marker
+			i += 2
+		default:
+			if i+4 > len(data) {
+				return 0, malformed("jpeg", "segment")
+			}
+			end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+			if end < i+4 || end > len(data) {
+				return 0, malformed("jpeg", "segment length")
+			}
+			i = end
+		}
+	}
+	return len(data), nil
+}
+
+var pngMetadata = map[string]bool{"tEXt": true, "zTXt": true, "iTXt": true, "eXIf": true, "tIME": true}
+
+// chunks describing the color space, which the standard library's PNG encoder doesn't write
+var pngColor = map[string]bool{"iCCP": true, "sRGB": true, "gAMA": true, "cHRM": true}
+
+func parsePNG(data []byte) (parsed, error) {
+	p := parsed{format: "png", kept: make([]byte, 0, len(data))}
+	p.kept = append(p.kept, data[:8]...)
+
+	for i := 8; ; {
+		if i+12 > len(data) {
+			return parsed{}, malformed("png", "has no IEND chunk")
+		}
+		n := int64(binary.BigEndian.Uint32(data[i:]))
+		if n > int64(len(data)-i-12) {
+			return parsed{}, malformed("png", "chunk length")
+		}
+		end := i + 12 + int(n)
+		typ := string(data[i+4 : i+8])
+
+		if pngMetadata[typ] {
+			if typ == "eXIf" && p.exif == nil {
+				p.exif = data[i+8 : end-4]
+			}
+			p.stripped = true
+		} else {
+			p.kept = append(p.kept, data[i:end]...)
+			if pngColor[typ] {
+				p.color = append(p.color, data[i:end]...)
+			}
+		}
+
+		if typ == "IEND" {
+			p.stripped = p.stripped || end < len(data)
+			return p, nil
+		}
+		i = end
+	}
+}
+
+// VP8X feature flags
+const (
+	webpExifFlag = 0x08
+	webpXMPFlag  = 0x04
+)
+
+func parseWebP(data []byte) (parsed, error) {
+	size := int64(binary.LittleEndian.Uint32(data[4:]))
+	if size < 4 || size > int64(len(data)-8) {
+		return parsed{}, malformed("webp", "riff length")
+	}
+	riffEnd := 8 + int(size)
+
+	p := parsed{format: "webp", kept: make([]byte, 0, riffEnd), stripped: riffEnd < len(data)}
+	p.kept = append(p.kept, data[:12]...)
+
+	for i := 12; i < riffEnd; {
+		if i+8 > riffEnd {
+			return parsed{}, malformed("webp", "chunk")
+		}
+		fourcc := string(data[i : i+4])
+		n := int64(binary.LittleEndian.Uint32(data[i+4:]))
+		if n > int64(riffEnd-i-8) {
+			return parsed{}, malformed("webp", "chunk length")
+		}
+		// chunks are padded to an even length, some encoders leave the padding off the last one
+		end := min(i+8+int(n)+int(n&1), riffEnd)
+
+		switch fourcc {
+		case "EXIF":
+			if p.exif == nil {
+				p.exif = data[i+8 : i+8+int(n)]
+			}
+			p.stripped = true
+		case "XMP ":
+			p.stripped = true
+		default:
+			p.kept = append(p.kept, data[i:end]...)
+			if (end-i)&1 == 1 {
+				p.kept = append(p.kept, 0)
+			}
+		}
+		i = end
+	}
+
+	return p, nil
+}
+
+// Clears the metadata flags of the VP8X chunk, appends an orientation only EXIF for orientations
+// other than 1 and fixes up the RIFF length.
+func finishWebP(kept []byte, orientation int) []byte {
+	exifFlag := false
+	if orientation != 1 {
+		exif := orientationExif(orientation)
+		kept = append(kept, "EXIF"...)
+		kept = binary.LittleEndian.AppendUint32(kept, uint32(len(exif)))
+		kept = append(kept, exif...)
+		exifFlag = true
+	}
+
+	if len(kept) >= 21 && string(kept[12:16]) == "VP8X" {
+		kept[20] &^= webpXMPFlag
+		if !exifFlag {
+			kept[20] &^= webpExifFlag
+		}
+	}
+
+	binary.LittleEndian.PutUint32(kept[4:], uint32(len(kept)-8))
+	return kept
+}
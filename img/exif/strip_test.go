@@ -0,0 +1,177 @@
+package exif
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+var (
+	red  = color.NRGBA{R: 255, A: 255}
+	blue = color.NRGBA{B: 255, A: 255}
+)
+
+// 4x2 image, red on the left half and blue on the right
+func testImage() image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		for x := range 4 {
+			if x < 2 {
+				img.Set(x, y, red)
+			} else {
+				img.Set(x, y, blue)
+			}
+		}
+	}
+	return img
+}
+
+func jpegSegment(marker byte, payload []byte) []byte {
+	b := []byte{0xff, marker}
+	b = binary.BigEndian.AppendUint16(b, uint16(len(payload)+2))
+	return append(b, payload...)
+}
+
+func pngChunk(typ string, data []byte) []byte {
+	b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	b = append(b, typ...)
+	b = append(b, data...)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b[4:]))
+}
+
+// JPEG with an EXIF orientation and a comment inserted after SOI
+func jpegWithOrientation(t *testing.T, o int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, testImage(), &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	exif := append([]byte("Exif\x00\x00"), orientationExif(o)...)
+	return bytes.Join([][]byte{raw[:2], jpegSegment(0xe1, exif), jpegSegment(0xfe, []byte("comment")), raw[2:]}, nil)
+}
+
+// PNG with an EXIF orientation and a text chunk inserted after IHDR
+func pngWithOrientation(t *testing.T, o int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage()); err != nil {
+		t.Fatal(err)
+	}
+	raw := buf.Bytes()
+	return bytes.Join([][]byte{raw[:33], pngChunk("tEXt", []byte("Author\x00someone")), pngChunk("eXIf", orientationExif(o)), raw[33:]}, nil)
+}
+
+func TestStripKeepsUprightImages(t *testing.T) {
+	data := jpegWithOrientation(t, 1)
+	out, err := Strip(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("Exif")) || bytes.Contains(out, []byte("comment")) {
+		t.Fatal("metadata left in the image")
+	}
+	// upright images are cut, not re-encoded: only the APP1 and COM segments are gone
+	removed := 4 + len("Exif\x00\x00") + len(orientationExif(1)) + 4 + len("comment")
+	if len(out) != len(data)-removed {
+		t.Fatalf("stripped %d of %d bytes, want %d", len(data)-len(out), len(data), removed)
+	}
+
+	again, err := Strip(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, out) {
+		t.Fatal("stripping a clean image changed it")
+	}
+}
+
+func TestStripRotatesJPEG(t *testing.T) {
+	out, err := Strip(jpegWithOrientation(t, 6))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o := Orientation(out); o != 1 {
+		t.Fatalf("orientation %d after Strip, want 1", o)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 2 || b.Dy() != 4 {
+		t.Fatalf("rotated to %dx%d, want 2x4", b.Dx(), b.Dy())
+	}
+}
+
+func TestStripRotatesPNG(t *testing.T) {
+	for _, tc := range []struct {
+		orientation   int
+		width, height int
+		topLeft       color.NRGBA
+	}{
+		{orientation: 3, width: 4, height: 2, topLeft: blue},
+		{orientation: 6, width: 2, height: 4, topLeft: red},
+		{orientation: 8, width: 2, height: 4, topLeft: blue},
+	} {
+		out, err := Strip(pngWithOrientation(t, tc.orientation))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(out, []byte("someone")) || Orientation(out) != 1 {
+			t.Fatalf("orientation %d: metadata left in the image", tc.orientation)
+		}
+		img, err := png.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != tc.width || b.Dy() != tc.height {
+			t.Fatalf("orientation %d: rotated to %dx%d, want %dx%d", tc.orientation, b.Dx(), b.Dy(), tc.width, tc.height)
+		}
+		if c := color.NRGBAModel.Convert(img.At(0, 0)); c != tc.topLeft {
+			t.Fatalf("orientation %d: top left %v, want %v", tc.orientation, c, tc.topLeft)
+		}
+	}
+}
+
+func TestStripWebPKeepsOrientation(t *testing.T) {
+	chunk := func(fourcc string, data []byte) []byte {
+		b := append([]byte(fourcc), binary.LittleEndian.AppendUint32(nil, uint32(len(data)))...)
+		b = append(b, data...)
+		if len(data)&1 == 1 {
+			b = append(b, 0)
+		}
+		return b
+	}
+	exif := make([]byte, 0, 64)
+	exif = append(exif, orientationExif(6)...)
+	exif = append(exif, "GPS 51.5"...)
+
+	// the image data isn't decoded, any VP8L payload will do
+	vp8x := make([]byte, 10)
+	vp8x[0] = webpExifFlag | webpXMPFlag
+	body := bytes.Join([][]byte{[]byte("WEBP"), chunk("VP8X", vp8x), chunk("VP8L", []byte("image")), chunk("XMP ", []byte("<x/>")), chunk("EXIF", exif)}, nil)
+	data := append([]byte("RIFF"), binary.LittleEndian.AppendUint32(nil, uint32(len(body)))...)
+	data = append(data, body...)
+
+	out, err := Strip(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(out, []byte("GPS")) || bytes.Contains(out, []byte("<x/>")) {
+		t.Fatal("metadata left in the image")
+	}
+	if o := Orientation(out); o != 6 {
+		t.Fatalf("orientation %d after Strip, want 6", o)
+	}
+	if flags := out[20]; flags&webpXMPFlag != 0 || flags&webpExifFlag == 0 {
+		t.Fatalf("VP8X flags %#x", flags)
+	}
+	if size := binary.LittleEndian.Uint32(out[4:]); int(size) != len(out)-8 {
+		t.Fatalf("riff size %d for %d bytes", size, len(out))
+	}
+}
@@ -0,0 +1,48 @@
+package resize
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// Scales src so its longest side is size pixels, keeping the aspect ratio.
+// Images already within size are returned as is.
+func Fit(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+
+	if w >= h {
+		h = max(1, h*size/w)
+		w = size
+	} else {
+		w = max(1, w*size/h)
+		h = size
+	}
+
+	return scale(src, b, w, h)
+}
+
+// Crops the centered square of src and scales it to size x size pixels.
+// Images smaller than size are cropped to their shortest side without upscaling.
+func Square(src image.Image, size int) image.Image {
+	b := src.Bounds()
+	side := min(b.Dx(), b.Dy())
+
+	x := b.Min.X + (b.Dx()-side)/2
+	y := b.Min.Y + (b.Dy()-side)/2
+	crop := image.Rect(x, y, x+side, y+side)
+
+	size = min(size, side)
+
+	return scale(src, crop, size, size)
+}
+
+func scale(src image.Image, from image.Rectangle, w int, h int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, from, draw.Src, nil)
+	return dst
+}